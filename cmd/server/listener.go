@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart systemd 传递的第一个监听套接字的文件描述符
+const listenFDsStart = 3
+
+// activationListeners 返回 systemd 套接字激活传入的监听器，未通过套接字激活启动时返回 nil
+// 按 .socket 单元中 ListenStream 的顺序排列：第一个用于主服务，第二个（如有）用于 HTTP 重定向
+func activationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	// 避免子进程误认为自己也是被激活的服务
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(file)
+		// FileListener 会复制文件描述符，原文件可以关闭
+		if cerr := file.Close(); cerr != nil {
+			log.Printf("failed to close activation fd %d: %v", fd, cerr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// unixSocketPath 判断监听地址是否为 unix 套接字，支持 "unix:/path" 和以 / 开头的路径
+func unixSocketPath(host string) (string, bool) {
+	if path, ok := strings.CutPrefix(host, "unix:"); ok {
+		return path, true
+	}
+	if strings.HasPrefix(host, "/") {
+		return host, true
+	}
+	return "", false
+}
+
+// listenUnix 在 path 上创建 unix 套接字并设置权限，启动前会删除上次残留的套接字文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		if cerr := ln.Close(); cerr != nil {
+			log.Printf("failed to close unix listener: %v", cerr)
+		}
+		return nil, err
+	}
+	return ln, nil
+}
+
+// parseSocketMode 解析八进制的套接字文件权限，如 "0660"，默认 0666
+func parseSocketMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0666, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenMain 创建主服务的监听器：优先使用 systemd 传入的套接字，其次是 unix 套接字，最后是 TCP 端口
+func listenMain(activated []net.Listener, host string, port int, socketMode string) (net.Listener, error) {
+	if len(activated) > 0 {
+		return activated[0], nil
+	}
+	if path, ok := unixSocketPath(host); ok {
+		mode, err := parseSocketMode(socketMode)
+		if err != nil {
+			return nil, err
+		}
+		return listenUnix(path, mode)
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// listenRedirect 创建 HTTP 重定向服务的监听器，主服务使用 unix 套接字且没有额外的激活套接字时返回 nil
+func listenRedirect(activated []net.Listener, host string, httpPort int) (net.Listener, error) {
+	if len(activated) > 1 {
+		return activated[1], nil
+	}
+	if _, ok := unixSocketPath(host); ok {
+		return nil, nil
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(httpPort)))
+}
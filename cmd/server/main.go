@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"flag"
 	"fmt"
@@ -119,6 +120,15 @@ func main() {
 		global.AppConfig.Security.SessionSecret = base64.StdEncoding.EncodeToString(sessionSecret)
 	}
 
+	// 签名链接使用单独的密钥，未配置时生成一个保存在数据库中，重启后之前签发的链接仍然有效
+	if global.AppConfig.Security.URLSigningSecret == "" {
+		secret, err := db.PersistentSecret("url_signing_secret")
+		if err != nil {
+			log.Fatal("Failed to load URL signing secret:", err)
+		}
+		global.AppConfig.Security.URLSigningSecret = secret
+	}
+
 	// 根据环境配置设置开发模式
 	global.IsDevelopment = global.AppConfig.Environment == "development"
 
@@ -153,6 +163,39 @@ func main() {
 		}
 	}()
 
+	// 启动过期图片清理定时器
+	go func() {
+		interval := 5 * time.Minute
+		if v := global.AppConfig.Upload.ExpiryInterval; v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				interval = d
+			} else {
+				logger.Warn("无效的过期清理间隔 %q，使用默认值 %v", v, interval)
+			}
+		}
+
+		expiryTicker := time.NewTicker(interval)
+		defer expiryTicker.Stop()
+
+		expireImages()
+		for range expiryTicker.C {
+			expireImages()
+		}
+	}()
+
+	// 启动过期断点续传上传清理定时器
+	if global.AppConfig.Upload.Tus.Enabled {
+		go func() {
+			tusTicker := time.NewTicker(time.Hour)
+			defer tusTicker.Stop()
+
+			handlers.CleanupTusUploads()
+			for range tusTicker.C {
+				handlers.CleanupTusUploads()
+			}
+		}()
+	}
+
 	r := mux.NewRouter()
 
 	// 静态文件
@@ -164,16 +207,42 @@ func main() {
 	r.HandleFunc("/", handlers.HandleHome).Methods("GET")
 	r.HandleFunc("/upload", middleware.RequireAuthForUpload(handlers.HandleUpload)).Methods("POST")
 	r.HandleFunc("/file/{uuid}", handlers.HandleImage).Methods("GET", "HEAD", "OPTIONS")
+	r.HandleFunc("/i/{code}", handlers.HandleImage).Methods("GET", "HEAD", "OPTIONS")
+	r.HandleFunc("/download/{uuid}", handlers.HandleImage).Methods("GET", "HEAD", "OPTIONS")
+	r.HandleFunc("/file/{uuid}", handlers.HandleUnlockImage).Methods("POST")
+	r.HandleFunc("/download/{uuid}", handlers.HandleUnlockImage).Methods("POST")
+	r.HandleFunc("/i/{code}", handlers.HandleUnlockImage).Methods("POST")
 	r.HandleFunc("/login", handlers.HandleLoginPage).Methods("GET")
 	r.HandleFunc("/login", handlers.HandleLogin).Methods("POST")
 	r.HandleFunc("/logout", handlers.HandleLogout).Methods("GET")
 	r.HandleFunc("/admin", middleware.RequireAuth(handlers.HandleAdmin)).Methods("GET")
 	r.HandleFunc("/admin/toggle/{id}", middleware.RequireAuth(handlers.HandleToggleStatus)).Methods("POST")
+	r.HandleFunc("/admin/private/{id}", middleware.RequireAuth(handlers.HandleTogglePrivate)).Methods("POST")
+	r.HandleFunc("/admin/hotlink/{id}", middleware.RequireAuth(handlers.HandleSetHotlinkPolicy)).Methods("POST")
+	r.HandleFunc("/admin/sign/{id}", middleware.RequireAuth(handlers.HandleAdminSign)).Methods("POST")
+	r.HandleFunc("/admin/similar", middleware.RequireAuth(handlers.HandleAdminSimilar)).Methods("GET")
+	r.HandleFunc("/admin/poster/{id}", middleware.RequireAuth(handlers.HandleAdminPoster)).Methods("GET")
+	r.HandleFunc("/admin/uploader/{app}", middleware.RequireAuth(handlers.HandleAdminUploaderConfig)).Methods("GET")
 
 	// RESTful API 路由
 	apiRouter := r.PathPrefix("/api/v1").Subrouter()
 	apiRouter.HandleFunc("/upload", middleware.RequireAPIKey(handlers.HandleAPIUpload)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/upload/batch", middleware.RequireAPIKey(handlers.HandleAPIUploadBatch)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/upload/url", middleware.RequireAPIKey(handlers.HandleAPIUploadURL)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/upload/base64", middleware.RequireAPIKey(handlers.HandleAPIUploadBase64)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/upload/raw", middleware.RequireAPIKey(handlers.HandleAPIUploadRaw)).Methods("POST", "PUT", "OPTIONS")
+	apiRouter.HandleFunc("/upload/simple", middleware.RequireAPIKey(handlers.HandleUploaderUpload)).Methods("POST", "OPTIONS")
+	// tus 断点续传，OPTIONS 用于协议发现和跨域预检，不要求 API Key
+	apiRouter.HandleFunc("/tus", handlers.HandleTusOptions).Methods("OPTIONS")
+	apiRouter.HandleFunc("/tus", middleware.RequireAPIKey(handlers.HandleTusCreate)).Methods("POST")
+	apiRouter.HandleFunc("/tus/{id}", handlers.HandleTusOptions).Methods("OPTIONS")
+	apiRouter.HandleFunc("/tus/{id}", middleware.RequireAPIKey(handlers.HandleTusHead)).Methods("HEAD")
+	apiRouter.HandleFunc("/tus/{id}", middleware.RequireAPIKey(handlers.HandleTusPatch)).Methods("PATCH")
+	apiRouter.HandleFunc("/tus/{id}", middleware.RequireAPIKey(handlers.HandleTusResult)).Methods("GET")
+	apiRouter.HandleFunc("/tus/{id}", middleware.RequireAPIKey(handlers.HandleTusDelete)).Methods("DELETE")
+	apiRouter.HandleFunc("/sign", middleware.RequireValidAPIKey(handlers.HandleAPISign)).Methods("POST")
 	apiRouter.HandleFunc("/health", handlers.HandleAPIHealthCheck).Methods("GET")
+	apiRouter.HandleFunc("/images/{uuid}", handlers.HandleAPIImageMetadata).Methods("GET")
 
 	// 状态监控和健康检查路由
 	r.HandleFunc("/health", handlers.HandleHealthCheck).Methods("GET")
@@ -188,13 +257,25 @@ func main() {
 	if host == "" {
 		host = "127.0.0.1"
 	}
-	addr := fmt.Sprintf("%s:%d", host, port)
+
+	// 创建监听器，site.host 可以是 unix 套接字路径，也可以由 systemd 套接字激活传入
+	activated, err := activationListeners()
+	if err != nil {
+		log.Fatalf("Failed to use socket activation: %v", err)
+	}
+	listener, err := listenMain(activated, host, port, global.AppConfig.Site.SocketMode)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	if len(activated) > 0 {
+		addr += " (systemd socket activation)"
+	}
 
 	// 添加优雅关闭超时配置
 	shutdownTimeout := 30 * time.Second
 
 	srv := &http.Server{
-		Addr:           addr,
 		Handler:        middleware.LoggingMiddleware(r), // 添加日志记录中间件
 		ReadTimeout:    15 * time.Second,
 		WriteTimeout:   30 * time.Second,  // 增加写入超时，处理大文件
@@ -202,10 +283,56 @@ func main() {
 		MaxHeaderBytes: 1 << 20,           // 限制请求头大小为 1MB
 	}
 
+	// 配置了证书时直接提供 HTTPS，同时启用 HTTP/2
+	tlsConfig := global.AppConfig.Site.TLS
+	useTLS := tlsConfig.CertFile != "" && tlsConfig.KeyFile != ""
+	var redirectSrv *http.Server
+	if useTLS {
+		reloader, err := newCertReloader(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		go reloader.watch()
+
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		if tlsConfig.RedirectHTTP {
+			httpPort := tlsConfig.HTTPPort
+			if httpPort == 0 {
+				httpPort = 80
+			}
+			redirectListener, err := listenRedirect(activated, host, httpPort)
+			if err != nil {
+				log.Fatalf("Failed to listen for HTTP redirect: %v", err)
+			}
+			if redirectListener == nil {
+				logger.Warn("使用 unix 套接字监听时不支持 HTTP 重定向，已忽略 redirectHTTP")
+			} else {
+				redirectSrv = newRedirectServer(port)
+				go func() {
+					log.Printf("HTTP redirect server is running on %s", redirectListener.Addr())
+					if err := redirectSrv.Serve(redirectListener); err != nil && err != http.ErrServerClosed {
+						log.Fatalf("HTTP redirect server error: %v", err)
+					}
+				}()
+			}
+		}
+	}
+
 	// 启动服务器
 	go func() {
-		log.Printf("Server is running on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if useTLS {
+			log.Printf("Server is running on %s (HTTPS)", addr)
+			err = srv.ServeTLS(listener, "", "")
+		} else {
+			log.Printf("Server is running on %s", addr)
+			err = srv.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
@@ -222,6 +349,12 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(ctx); err != nil {
+			logger.Error("HTTP 重定向服务器关闭错误: %v", err)
+		}
+	}
+
 	logger.Info("正在关闭 HTTP 服务器...")
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("HTTP 服务器关闭错误: %v", err)
@@ -257,3 +390,127 @@ func cleanURLCache() {
 
 	logger.Info("URL缓存清理完成，共清理 %d 个过期项", count)
 }
+
+// expiredImage 已过期等待下线的图片
+type expiredImage struct {
+	id        int64
+	fileID    string
+	chatID    int64
+	messageID int
+}
+
+// expireImages 将超过有效期或访问次数用完的图片标记为已删除，按配置删除 Telegram 中的消息
+func expireImages() {
+	var expired []expiredImage
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		rows, err := global.DB.QueryContext(ctx, `
+			SELECT id, file_id, chat_id, message_id
+			FROM images
+			WHERE is_active = 1 AND (
+				(expires_at IS NOT NULL AND expires_at <= datetime('now')) OR
+				(max_views > 0 AND view_count >= max_views)
+			)`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var img expiredImage
+			if err := rows.Scan(&img.id, &img.fileID, &img.chatID, &img.messageID); err != nil {
+				return err
+			}
+			expired = append(expired, img)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		logger.Error("查询过期图片失败: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	count := 0
+	for _, img := range expired {
+		err := db.WithDBTimeout(func(ctx context.Context) error {
+			_, err := global.DB.ExecContext(ctx, "UPDATE images SET is_active = 0 WHERE id = ?", img.id)
+			return err
+		})
+		if err != nil {
+			logger.Error("标记过期图片 %d 失败: %v", img.id, err)
+			continue
+		}
+		count++
+
+		if !global.AppConfig.Upload.DeleteExpired {
+			continue
+		}
+
+		// 消息可能在本轮中由先过期的记录转交过来，重新读取
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			return global.DB.QueryRowContext(ctx,
+				"SELECT chat_id, message_id FROM images WHERE id = ?", img.id,
+			).Scan(&img.chatID, &img.messageID)
+		})
+		if err != nil {
+			logger.Error("读取图片 %d 的 Telegram 消息失败: %v", img.id, err)
+			continue
+		}
+		if img.messageID == 0 {
+			continue
+		}
+
+		// 去重时多条记录共用同一个 Telegram 文件，只有第一条记录保存了消息ID
+		// 仍有活跃记录时把消息转交给其中一条，最后一条记录过期时再删除消息
+		var transferred bool
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			result, err := global.DB.ExecContext(ctx, `
+				UPDATE images SET chat_id = ?, message_id = ?
+				WHERE id = (
+					SELECT id FROM images WHERE file_id = ? AND is_active = 1 AND message_id = 0 ORDER BY id LIMIT 1
+				)`,
+				img.chatID, img.messageID, img.fileID,
+			)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil || n == 0 {
+				return err
+			}
+			transferred = true
+			_, err = global.DB.ExecContext(ctx, "UPDATE images SET message_id = 0 WHERE id = ?", img.id)
+			return err
+		})
+		if err != nil {
+			logger.Error("转交图片 %d 的 Telegram 消息失败: %v", img.id, err)
+			continue
+		}
+		if transferred {
+			continue
+		}
+
+		// 其他活跃记录已经各自保存了消息时同样保留
+		var shared int
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			return global.DB.QueryRowContext(ctx,
+				"SELECT COUNT(*) FROM images WHERE file_id = ? AND is_active = 1", img.fileID,
+			).Scan(&shared)
+		})
+		if err != nil {
+			logger.Error("检查图片 %d 的共享文件失败: %v", img.id, err)
+			continue
+		}
+		if shared > 0 {
+			continue
+		}
+
+		if err := telegram.DeleteMessage(img.chatID, img.messageID); err != nil {
+			logger.Warn("删除图片 %d 的 Telegram 消息失败: %v", img.id, err)
+		}
+	}
+
+	logger.Info("过期图片清理完成，共下线 %d 张图片", count)
+}
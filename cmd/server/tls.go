@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"hosting/internal/logger"
+)
+
+// certCheckInterval 检查证书文件是否变化的间隔
+const certCheckInterval = time.Minute
+
+// certReloader 持有当前使用的证书，证书文件变化或收到 SIGHUP 时重新加载
+// 新证书只对之后的 TLS 握手生效，已建立的连接不受影响
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader 加载证书并返回 reloader，证书无法加载时返回错误
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload 重新读取证书和私钥，失败时继续使用原有证书
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// latestModTime 返回证书和私钥文件中较新的修改时间
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate 供 tls.Config 使用，返回当前证书
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// watch 定期检查证书文件的修改时间，并在收到 SIGHUP 时强制重新加载
+func (cr *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			logger.Info("收到 SIGHUP，重新加载 TLS 证书")
+		case <-ticker.C:
+			modTime, err := cr.latestModTime()
+			if err != nil {
+				logger.Warn("检查 TLS 证书文件失败: %v", err)
+				continue
+			}
+			cr.mu.RLock()
+			changed := modTime.After(cr.modTime)
+			cr.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			logger.Info("检测到 TLS 证书文件变化，重新加载")
+		}
+
+		if err := cr.reload(); err != nil {
+			logger.Error("重新加载 TLS 证书失败，继续使用原证书: %v", err)
+			continue
+		}
+		logger.Info("TLS 证书重新加载成功")
+	}
+}
+
+// newRedirectServer 创建将 HTTP 请求重定向到 HTTPS 的服务器
+func newRedirectServer(httpsPort int) *http.Server {
+	return &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hostname := r.Host
+			if h, _, err := net.SplitHostPort(hostname); err == nil {
+				hostname = h
+			}
+			hostname = strings.Trim(hostname, "[]")
+
+			target := net.JoinHostPort(hostname, strconv.Itoa(httpsPort))
+			if httpsPort == 443 {
+				target = strings.TrimSuffix(target, ":443")
+			}
+			http.Redirect(w, r, fmt.Sprintf("https://%s%s", target, r.URL.RequestURI()), http.StatusMovedPermanently)
+		}),
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		IdleTimeout:    30 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+}
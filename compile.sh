@@ -91,6 +91,8 @@ for PLATFORM in "${SERVER_PLATFORMS[@]}"; do
         cp ./templates/login.tmpl "$PACK_DIR/imagehosting/templates/"
         cp ./templates/upload.tmpl "$PACK_DIR/imagehosting/templates/"
         cp ./templates/admin.tmpl "$PACK_DIR/imagehosting/templates/"
+        cp ./templates/similar.tmpl "$PACK_DIR/imagehosting/templates/"
+        cp ./templates/unlock.tmpl "$PACK_DIR/imagehosting/templates/"
         
         # 创建ZIP文件
         echo "📦 打包服务器为 $SERVER_ZIP_NAME..."
@@ -0,0 +1,199 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter 令牌桶限速器，按字节计量，桶容量为一秒的流量
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64 // 每秒补充的字节数
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+}
+
+// NewLimiter 创建每秒 bytesPerSec 字节的限速器
+func NewLimiter(bytesPerSec int64) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		rate:     float64(bytesPerSec),
+		tokens:   float64(bytesPerSec),
+		last:     now,
+		lastUsed: now,
+	}
+}
+
+// Burst 返回单次可以申请的最大字节数
+func (l *Limiter) Burst() int {
+	return max(int(l.rate), 1)
+}
+
+// WaitN 申请 n 个字节的额度，额度不足时阻塞到可用或 ctx 结束
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.lastUsed = now
+	// 先预留额度，令牌可以为负数，后续请求会相应等待更久
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idle 限速器最近一次使用距今的时间
+func (l *Limiter) idle(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return now.Sub(l.lastUsed)
+}
+
+// Group 按键（如客户端 IP）分别限速的限速器集合
+type Group struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	limiters    map[string]*Limiter
+	lastSweep   time.Time
+}
+
+// NewGroup 创建每个键每秒 bytesPerSec 字节的限速器集合
+func NewGroup(bytesPerSec int64) *Group {
+	return &Group{
+		bytesPerSec: bytesPerSec,
+		limiters:    make(map[string]*Limiter),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Get 返回指定键的限速器，不存在时创建
+func (g *Group) Get(key string) *Limiter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// 定期清理长时间未使用的限速器，避免占用内存
+	now := time.Now()
+	if now.Sub(g.lastSweep) > time.Minute {
+		for k, l := range g.limiters {
+			if l.idle(now) > 10*time.Minute {
+				delete(g.limiters, k)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	l, ok := g.limiters[key]
+	if !ok {
+		l = NewLimiter(g.bytesPerSec)
+		g.limiters[key] = l
+	}
+	return l
+}
+
+// DailyCounter 按天统计每个键的流量，每天零点（本地时间）重新计数
+type DailyCounter struct {
+	mu     sync.Mutex
+	day    string
+	counts map[int]int64
+}
+
+// NewDailyCounter 创建按天统计的流量计数器
+func NewDailyCounter() *DailyCounter {
+	return &DailyCounter{counts: make(map[int]int64)}
+}
+
+// rollover 日期变化时清空计数，调用方需持有锁
+func (c *DailyCounter) rollover() {
+	today := time.Now().Format(time.DateOnly)
+	if c.day != today {
+		c.day = today
+		clear(c.counts)
+	}
+}
+
+// Used 返回指定键今天已使用的流量
+func (c *DailyCounter) Used(key int) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollover()
+	return c.counts[key]
+}
+
+// Add 累加指定键今天的流量
+func (c *DailyCounter) Add(key int, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollover()
+	c.counts[key] += n
+}
+
+// UntilReset 距离下次重新计数的时间
+func UntilReset() time.Duration {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	return midnight.Sub(now)
+}
+
+// Writer 按限速器写入数据，每次写入后调用 onWrite 记录字节数
+type Writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+	onWrite  func(n int)
+}
+
+// NewWriter 创建受 limiters 共同限制的 Writer，limiters 中的 nil 会被忽略
+func NewWriter(ctx context.Context, w io.Writer, onWrite func(n int), limiters ...*Limiter) *Writer {
+	var active []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	return &Writer{ctx: ctx, w: w, limiters: active, onWrite: onWrite}
+}
+
+// Write 将数据按限速器的桶容量分块写入
+func (tw *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		for _, l := range tw.limiters {
+			chunk = min(chunk, l.Burst())
+		}
+		for _, l := range tw.limiters {
+			if err := l.WaitN(tw.ctx, chunk); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := tw.w.Write(p[:chunk])
+		written += n
+		if tw.onWrite != nil && n > 0 {
+			tw.onWrite(n)
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
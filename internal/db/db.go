@@ -2,7 +2,10 @@ package db
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"fmt"
 	"log"
 	"time"
 
@@ -11,6 +14,31 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+// imageColumns 是在初始表结构之后新增的 images 字段，启动时按需补齐
+var imageColumns = []struct {
+	name       string
+	definition string
+}{
+	{"file_hash", "TEXT"}, // 文件内容的 SHA-256，用于去重
+	{"phash", "TEXT"},     // 感知哈希（dHash），用于查找相似图片
+	{"width", "INTEGER DEFAULT 0"},
+	{"height", "INTEGER DEFAULT 0"},
+	{"blurhash", "TEXT"},                  // BlurHash 占位图
+	{"dominant_color", "TEXT"},            // 主色调 #rrggbb
+	{"slug", "TEXT"},                      // 自定义链接名或短链接
+	{"is_private", "BOOLEAN DEFAULT 0"},   // 私有图片只能通过签名链接访问
+	{"hotlink_policy", "TEXT DEFAULT ''"}, // 防盗链策略：空为跟随全局，allow 或 protect
+	{"expires_at", "DATETIME"},            // 过期时间（UTC），NULL 表示永久有效
+	{"max_views", "INTEGER DEFAULT 0"},    // 最大访问次数，0 表示不限制
+	{"message_id", "INTEGER DEFAULT 0"},   // Telegram 消息ID，用于过期后删除消息
+	{"chat_id", "INTEGER DEFAULT 0"},      // 消息所在的 Telegram 会话
+	{"password_hash", "TEXT"},             // 访问密码的 PBKDF2 哈希，为空表示无需密码
+	{"duration", "REAL DEFAULT 0"},        // 视频时长（秒），图片为 0
+	{"thumb_file_id", "TEXT"},             // Telegram 为视频生成的封面帧
+	{"api_key_id", "TEXT"},                // 上传使用的 API Key 的哈希标识，匿名上传为空
+	{"file_size", "INTEGER DEFAULT 0"},    // 保存的文件大小（字节），用于统计 API Key 的存储配额
+}
+
 func InitDB() {
 	// 获取数据库路径（从配置文件加载，无默认值）
 	dbPath := global.AppConfig.Database.Path
@@ -57,6 +85,36 @@ func InitDB() {
 		log.Fatal(err)
 	}
 
+	// 为旧版本创建的表补充新增字段
+	for _, col := range imageColumns {
+		if err := ensureColumn("images", col.name, col.definition); err != nil {
+			log.Fatalf("Failed to migrate column %s: %v", col.name, err)
+		}
+	}
+
+	// API Key 每天的上传用量，用于配额限制
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS api_key_usage (
+		key_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		uploads INTEGER DEFAULT 0,
+		bytes INTEGER DEFAULT 0,
+		PRIMARY KEY (key_id, day)
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 需要在重启后保持不变的设置，如自动生成的密钥
+	_, err = global.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// 创建优化的索引
 	_, err = global.DB.Exec(`
     -- 优化查询时的索引
@@ -64,6 +122,10 @@ func InitDB() {
     CREATE INDEX IF NOT EXISTS idx_upload_time ON images(upload_time);
     CREATE INDEX IF NOT EXISTS idx_is_active ON images(is_active);
     CREATE INDEX IF NOT EXISTS idx_file_id ON images(file_id);
+    CREATE INDEX IF NOT EXISTS idx_file_hash ON images(file_hash);
+    CREATE INDEX IF NOT EXISTS idx_expires_at ON images(expires_at) WHERE expires_at IS NOT NULL;
+    CREATE UNIQUE INDEX IF NOT EXISTS idx_slug ON images(slug) WHERE slug IS NOT NULL;
+    CREATE INDEX IF NOT EXISTS idx_api_key_id ON images(api_key_id) WHERE api_key_id IS NOT NULL;
     
     -- 复合索引，优化管理页面查询
     CREATE INDEX IF NOT EXISTS idx_active_time ON images(is_active, upload_time DESC);
@@ -105,3 +167,67 @@ func WithDBTimeout(f func(context.Context) error) error {
 	defer cancel()
 	return f(ctx)
 }
+
+// PersistentSecret 返回保存在数据库中的随机密钥，第一次调用时生成
+func PersistentSecret(name string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var secret string
+	err := WithDBTimeout(func(ctx context.Context) error {
+		// 已经存在时保留原来的值，多个进程同时启动也只会生成一个
+		if _, err := global.DB.ExecContext(ctx,
+			"INSERT OR IGNORE INTO settings (key, value) VALUES (?, ?)", name, base64.StdEncoding.EncodeToString(buf),
+		); err != nil {
+			return err
+		}
+		return global.DB.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = ?", name).Scan(&secret)
+	})
+	return secret, err
+}
+
+// ensureColumn 检查表中是否存在指定字段，不存在时通过 ALTER TABLE 添加
+func ensureColumn(table, column, definition string) error {
+	exists, err := hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	if _, err := global.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return err
+	}
+	log.Printf("Database migrated: added column %s.%s", table, column)
+	return nil
+}
+
+// hasColumn 通过 PRAGMA table_info 判断字段是否存在
+func hasColumn(table, column string) (bool, error) {
+	rows, err := global.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			log.Printf("failed to close rows: %v", cerr)
+		}
+	}()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -28,11 +28,21 @@ var (
 
 	// 允许的文件类型
 	AllowedMimeTypes = map[string]string{
-		"image/jpeg": ".jpg",
-		"image/jpg":  ".jpg",
-		"image/png":  ".png",
-		"image/gif":  ".gif",
-		"image/webp": ".webp",
+		"image/jpeg":      ".jpg",
+		"image/jpg":       ".jpg",
+		"image/png":       ".png",
+		"image/gif":       ".gif",
+		"image/webp":      ".webp",
+		"image/avif":      ".avif",
+		"image/heic":      ".heic",
+		"image/heif":      ".heif",
+		"image/bmp":       ".bmp",
+		"image/tiff":      ".tiff",
+		"image/x-icon":    ".ico",
+		"image/svg+xml":   ".svg",
+		"video/mp4":       ".mp4",
+		"video/webm":      ".webm",
+		"video/quicktime": ".mov",
 	}
 
 	IsDevelopment = false // 开发环境标志，默认为生产环境
@@ -50,8 +60,9 @@ type Config struct {
 		ChatID int64  `json:"chatId"`
 	} `json:"telegram"`
 	Admin struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username         string `json:"username"`
+		Password         string `json:"password"`
+		SimilarThreshold int    `json:"similarThreshold"` // 相似图片判定的汉明距离阈值，默认 10
 	} `json:"admin"`
 	Database struct {
 		Path            string `json:"path"`
@@ -60,28 +71,85 @@ type Config struct {
 		ConnMaxLifetime string `json:"connMaxLifetime"`
 	} `json:"database"`
 	Site struct {
-		Name        string `json:"name"`
-		Favicon     string `json:"favicon"`
-		MaxFileSize int    `json:"maxFileSize"`
-		Port        int    `json:"port"`
-		Host        string `json:"host"`
+		Name        string   `json:"name"`
+		Favicon     string   `json:"favicon"`
+		MaxFileSize int      `json:"maxFileSize"`
+		Port        int      `json:"port"`
+		Host        string   `json:"host"`        // 监听地址，也可以是 unix 套接字路径，如 "unix:/run/goimage.sock"
+		SocketMode  string   `json:"socketMode"`  // unix 套接字文件权限，默认 "0666"
+		LinkFormats []string `json:"linkFormats"` // 上传结果中提供的链接格式及顺序，默认全部：url、markdown、html、bbcode、bbcodeClickable
+		TLS         struct {
+			CertFile     string `json:"certFile"`     // 证书文件路径，与 keyFile 同时配置时直接提供 HTTPS
+			KeyFile      string `json:"keyFile"`      // 私钥文件路径
+			RedirectHTTP bool   `json:"redirectHTTP"` // 是否额外监听 HTTP 端口并重定向到 HTTPS
+			HTTPPort     int    `json:"httpPort"`     // HTTP 重定向监听端口，默认 80
+		} `json:"tls"`
 	} `json:"site"`
+	Upload struct {
+		DedupMode       string `json:"dedupMode"`       // 重复文件处理方式: "reuse"(默认)、"link" 或 "off"
+		ShortCodeLength int    `json:"shortCodeLength"` // 短链接长度，默认 6
+		ExpiryInterval  string `json:"expiryInterval"`  // 过期图片清理任务的执行间隔，默认 "5m"
+		DeleteExpired   bool   `json:"deleteExpired"`   // 图片过期后是否同时删除 Telegram 中的消息
+		Files           struct {
+			Enabled bool     `json:"enabled"` // 是否允许上传图片和视频以外的文件
+			Allow   []string `json:"allow"`   // 允许的类型，如 ".pdf"、"application/zip"、"audio/*"，为空时允许所有未禁止的类型
+			Deny    []string `json:"deny"`    // 禁止的类型，优先于 allow
+		} `json:"files"`
+		Remote struct {
+			Enabled      bool   `json:"enabled"`      // 是否允许通过链接导入远程图片
+			Timeout      string `json:"timeout"`      // 下载远程文件的超时时间，默认 "15s"
+			MaxRedirects int    `json:"maxRedirects"` // 最多跟随的重定向次数，默认 3
+		} `json:"remote"`
+		Batch struct {
+			MaxFiles     int `json:"maxFiles"`     // 单次请求最多上传的文件数，默认 10
+			MaxTotalSize int `json:"maxTotalSize"` // 单次请求所有文件的总大小上限（MB），默认 100
+		} `json:"batch"`
+		Tus struct {
+			Enabled    bool   `json:"enabled"`    // 是否开启 /api/v1/tus 断点续传上传
+			Dir        string `json:"dir"`        // 分块数据的暂存目录，默认为系统临时目录下的 goimage-tus
+			Expiration string `json:"expiration"` // 未完成的上传保留的时间，默认 "24h"
+		} `json:"tus"`
+	} `json:"upload"`
+	Bandwidth struct {
+		PerIPKBps    int `json:"perIPKBps"`    // 每个客户端 IP 的最大下载速度（KB/s），0 表示不限制
+		GlobalKBps   int `json:"globalKBps"`   // 全站总出口带宽（KB/s），0 表示不限制
+		ImageDailyMB int `json:"imageDailyMB"` // 单张图片每日最大流量（MB），超出后返回 429，0 表示不限制
+	} `json:"bandwidth"`
 	Security struct {
 		RateLimit struct {
 			Enabled bool   `json:"enabled"`
 			Limit   int    `json:"limit"`
 			Window  string `json:"window"`
 		} `json:"rateLimit"`
-		AllowedHosts          []string `json:"allowedHosts"`
+		AllowedHosts   []string `json:"allowedHosts"`   // 允许引用图片的站点，支持 *.example.com 通配
+		TrustedProxies []string `json:"trustedProxies"` // 可信的反向代理地址或网段，只有来自这些地址的请求才读取 X-Forwarded-For
+		Hotlink        struct {
+			Enabled           bool   `json:"enabled"`           // 是否启用防盗链
+			AllowEmptyReferer bool   `json:"allowEmptyReferer"` // 是否允许没有 Referer 的请求（直接访问、部分 App）
+			Placeholder       string `json:"placeholder"`       // 盗链时返回的占位图片路径，留空返回 403
+		} `json:"hotlink"`
 		SessionSecret         string   `json:"sessionSecret"`         // session secret 配置
+		URLSigningSecret      string   `json:"urlSigningSecret"`      // 签名链接密钥，留空时自动生成并保存在数据库中
+		SignedURLTTL          string   `json:"signedURLTTL"`          // 签名链接默认有效期，如 "24h"
 		StatusKey             string   `json:"statusKey"`             // 状态页面访问密钥
 		APIKeys               []string `json:"apiKeys"`               // API 密钥列表
 		RequireAPIKey         bool     `json:"requireAPIKey"`         // 是否强制要求 API Key
 		RequireLoginForUpload bool     `json:"requireLoginForUpload"` // 是否要求登录才能上传
+		Quota                 struct {
+			Default QuotaLimits            `json:"default"` // 所有 API Key 的默认配额
+			Keys    map[string]QuotaLimits `json:"keys"`    // 单独为某些 API Key 设置的配额，整体覆盖默认配额
+		} `json:"quota"`
 	} `json:"security"`
 	Environment string `json:"environment"` // 可选值: "development" 或 "production"
 }
 
+// QuotaLimits 单个 API Key 的上传配额，0 表示不限制
+type QuotaLimits struct {
+	UploadsPerDay int `json:"uploadsPerDay"` // 每天最多上传的文件数
+	DailyMB       int `json:"dailyMB"`       // 每天最多上传的总大小（MB）
+	StorageMB     int `json:"storageMB"`     // 仍然有效的文件总大小上限（MB），已删除或过期的文件不计入
+}
+
 // ImageRecord 图片记录结构
 type ImageRecord struct {
 	ID          int
@@ -94,6 +162,11 @@ type ImageRecord struct {
 	ContentType string
 	IsActive    bool
 	ViewCount   int
+	IsPrivate   bool
+	Hotlink     string
+	HasPassword bool
+	HasPoster   bool    // 视频是否有 Telegram 生成的封面帧
+	Duration    float64 // 视频时长（秒）
 }
 
 // FileURLCache 用于缓存文件URL
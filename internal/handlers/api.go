@@ -2,20 +2,20 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 
 	"hosting/internal/db"
 	"hosting/internal/global"
 	"hosting/internal/logger"
-	"hosting/internal/utils"
 )
 
 // APIResponse 定义通用API响应结构
@@ -27,22 +27,137 @@ type APIResponse struct {
 
 // ImageResponse 包含上传后的图片信息
 type ImageResponse struct {
-	URL         string `json:"url"`
-	Filename    string `json:"filename"`
-	ContentType string `json:"contentType"`
-	Size        int64  `json:"size"`
-	UploadTime  string `json:"uploadTime"`
+	URL           string            `json:"url"`
+	Filename      string            `json:"filename"`
+	ContentType   string            `json:"contentType"`
+	Size          int64             `json:"size"`
+	UploadTime    string            `json:"uploadTime"`
+	Width         int               `json:"width,omitempty"`
+	Height        int               `json:"height,omitempty"`
+	BlurHash      string            `json:"blurhash,omitempty"`
+	DominantColor string            `json:"dominantColor,omitempty"`
+	ExpiresAt     string            `json:"expiresAt,omitempty"`         // 过期时间，未设置有效期时为空
+	MaxViews      int               `json:"maxViews,omitempty"`          // 最大访问次数，未限制时为空
+	Protected     bool              `json:"passwordProtected,omitempty"` // 是否需要密码才能访问
+	Duration      float64           `json:"duration,omitempty"`          // 视频时长（秒）
+	Links         map[string]string `json:"links,omitempty"`             // 可直接复制的链接和嵌入代码，键为格式名称
 }
 
+// ImageMetadata 图片元数据，供前端在图片加载完成前渲染占位图
+type ImageMetadata struct {
+	URL           string  `json:"url"`
+	Filename      string  `json:"filename"`
+	ContentType   string  `json:"contentType"`
+	UploadTime    string  `json:"uploadTime"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	BlurHash      string  `json:"blurhash,omitempty"`
+	DominantColor string  `json:"dominantColor,omitempty"`
+	Duration      float64 `json:"duration,omitempty"` // 视频时长（秒）
+}
+
+// apiSourceFunc 从请求中取得待上传的文件，失败时返回提示信息和状态码
+// 不同来源的请求体格式不同，请求体大小限制由各自设置
+type apiSourceFunc func(w http.ResponseWriter, r *http.Request, requestID string) (*uploadSource, string, int)
+
 // HandleAPIUpload 处理通过API上传图片
 func HandleAPIUpload(w http.ResponseWriter, r *http.Request) {
+	serveAPIUpload(w, r, multipartSource([]string{"image"}))
+}
+
+// multipartSource 流式读取多部分表单中 fields 字段的单个文件，文件直接写入本地副本
+func multipartSource(fields []string) apiSourceFunc {
+	return func(w http.ResponseWriter, r *http.Request, requestID string) (*uploadSource, string, int) {
+		maxSize := maxUploadSize()
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+maxOptionsBodySize)
+		form, err := readMultipartForm(r, fields, 1, maxSize)
+		if errors.Is(err, errTooManyFiles) {
+			return nil, "一次只能上传一个文件，多个文件请使用 /api/v1/upload/batch", http.StatusBadRequest
+		}
+		if isTooLarge(err) {
+			return nil, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest
+		}
+		if err != nil {
+			logger.Warn("[%s] 解析表单数据失败: %v", requestID, err)
+			return nil, "无法解析表单数据", http.StatusBadRequest
+		}
+		if len(form.Files) == 0 {
+			return nil, "无法读取上传文件", http.StatusBadRequest
+		}
+		if isTooLarge(form.Files[0].Err) {
+			return nil, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest
+		}
+		return form.source(0), "", 0
+	}
+}
+
+// HandleAPIUploadURL 从远程链接导入图片，下载完成后与普通上传走相同的校验和存储流程
+func HandleAPIUploadURL(w http.ResponseWriter, r *http.Request) {
+	serveAPIUpload(w, r, func(w http.ResponseWriter, r *http.Request, requestID string) (*uploadSource, string, int) {
+		if !remoteEnabled() {
+			return nil, "服务器未开启链接导入", http.StatusForbidden
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxOptionsBodySize)
+		rawURL := strings.TrimSpace(r.FormValue("url"))
+		if rawURL == "" {
+			return nil, "缺少 url 参数", http.StatusBadRequest
+		}
+		upload, err := remoteFileSource(r.Context(), rawURL)
+		if err != nil {
+			logger.Warn("[%s] 下载远程文件失败 %s: %v", requestID, remoteSourceLabel(rawURL), err)
+			message, code := remoteErrorResponse(err)
+			return nil, message, code
+		}
+		return upload, "", 0
+	})
+}
+
+// apiResponder 将上传结果写入响应，上传工具兼容接口使用与标准 API 不同的响应格式
+type apiResponder interface {
+	success(w http.ResponseWriter, requestID string, result *uploadResult)
+	failure(w http.ResponseWriter, message string, statusCode int)
+}
+
+// standardResponder 标准 API 的响应格式，即 APIResponse
+type standardResponder struct{}
+
+func (standardResponder) success(w http.ResponseWriter, requestID string, result *uploadResult) {
+	response := APIResponse{
+		Success: true,
+		Message: "上传成功",
+		Data:    result.imageResponse(),
+	}
+	statusCode := http.StatusCreated
+	if result.Reused {
+		// 文件已存在时返回原有链接，不再创建新资源
+		response.Message = "文件已存在，返回已有链接"
+		statusCode = http.StatusOK
+	}
+
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("[%s] failed to write JSON response: %v", requestID, err)
+	}
+}
+
+func (standardResponder) failure(w http.ResponseWriter, message string, statusCode int) {
+	sendJSONError(w, message, statusCode)
+}
+
+// serveAPIUpload 以标准 API 格式处理上传
+func serveAPIUpload(w http.ResponseWriter, r *http.Request, getSource apiSourceFunc) {
+	serveAPIUploadAs(w, r, getSource, standardResponder{})
+}
+
+// serveAPIUploadAs 处理 API 上传的公共流程：跨域、超时和并发控制，然后校验并保存 getSource 返回的文件，结果由 respond 写入响应
+func serveAPIUploadAs(w http.ResponseWriter, r *http.Request, getSource apiSourceFunc, respond apiResponder) {
 	// 设置响应类型为JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	// 处理跨域请求
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PUT")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Filename")
 
 	// 处理预检请求
 	if r.Method == "OPTIONS" {
@@ -58,7 +173,7 @@ func HandleAPIUpload(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error("[%s] API上传处理中发生panic: %v", requestID, err)
-			sendJSONError(w, "服务器内部错误", http.StatusInternalServerError)
+			respond.failure(w, "服务器内部错误", http.StatusInternalServerError)
 		}
 	}()
 
@@ -72,209 +187,86 @@ func HandleAPIUpload(w http.ResponseWriter, r *http.Request) {
 	case global.UploadSemaphore <- struct{}{}:
 		defer func() { <-global.UploadSemaphore }()
 	default:
-		sendJSONError(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
-		return
-	}
-
-	// 限制上传文件大小
-	maxSize := int64(global.AppConfig.Site.MaxFileSize * 1024 * 1024)
-	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
-
-	// 解析多部分表单
-	err := r.ParseMultipartForm(maxSize)
-	if err != nil {
-		sendJSONError(w, "无法解析表单数据", http.StatusBadRequest)
+		respond.failure(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
 		return
 	}
 
 	// 获取上传文件
-	file, header, err := r.FormFile("image")
-	if err != nil {
-		sendJSONError(w, "无法读取上传文件", http.StatusBadRequest)
-		return
-	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			logger.Error("[%s] failed to close uploaded file: %v", requestID, cerr)
-		}
-	}()
-
-	// 检查文件大小
-	if header.Size > maxSize {
-		sendJSONError(w, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest)
+	upload, message, code := getSource(w, r, requestID)
+	if upload == nil {
+		respond.failure(w, message, code)
 		return
 	}
+	defer upload.Close()
 
-	// 检查文件类型
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		sendJSONError(w, "读取文件内容失败", http.StatusInternalServerError)
-		return
-	}
-	if _, err = file.Seek(0, 0); err != nil {
-		sendJSONError(w, "读取文件失败", http.StatusInternalServerError)
+	result, uploadErr := storeUpload(r.Context(), newUploadRequest(r, requestID, upload))
+	if uploadErr != nil {
+		respond.failure(w, uploadErr.Message, uploadErr.Code)
 		return
 	}
+	respond.success(w, requestID, result)
+}
 
-	contentType := http.DetectContentType(buffer)
-	fileExt, ok := utils.GetFileExtension(contentType)
-	if !ok {
-		originalExt := utils.NormalizeFileExtension(header.Filename)
-		for mime, ext := range global.AllowedMimeTypes {
-			if ext == originalExt {
-				fileExt = ext
-				contentType = mime
-				ok = true
-				break
-			}
-		}
-
-		if !ok {
-			sendJSONError(w, "不支持的文件类型，仅支持JPG/JPEG, PNG, GIF和WebP格式", http.StatusBadRequest)
-			return
-		}
-	}
+// HandleAPIImageMetadata 返回图片的尺寸、BlurHash、主色调和视频时长等元数据
+func HandleAPIImageMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// 记录客户端信息
-	ipAddress := utils.ValidateIPAddress(r.RemoteAddr)
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ipAddress = utils.ValidateIPAddress(forwardedFor)
-	}
-	userAgent := utils.SanitizeUserAgent(r.Header.Get("User-Agent"))
-	filename := utils.SanitizeFilename(header.Filename)
+	uuid := mux.Vars(r)["uuid"]
 
-	// 创建临时文件
-	tempFile, err := os.CreateTemp("", "upload-*"+fileExt)
-	if err != nil {
-		sendJSONError(w, "创建临时文件失败", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if cerr := os.Remove(tempFile.Name()); cerr != nil {
-			logger.Error("[%s] failed to remove temp file %s: %v", requestID, tempFile.Name(), cerr)
-		}
-	}()
-	defer func() {
-		if cerr := tempFile.Close(); cerr != nil {
-			logger.Error("[%s] failed to close temp file %s: %v", requestID, tempFile.Name(), cerr)
-		}
-	}()
-
-	// 复制上传文件到临时文件
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		sendJSONError(w, "保存上传文件失败", http.StatusInternalServerError)
+	var meta ImageMetadata
+	var proxyURL string
+	var isActive, isPrivate, hasPassword, isExpired, isLimited bool
+	var blurHash, dominantColor sql.NullString
+	clause, args, ok := imageLookupClause(uuid)
+	if !ok {
+		sendJSONError(w, "图片不存在", http.StatusNotFound)
 		return
 	}
-
-	// 根据文件类型选择发送方式
-	var message tgbotapi.Message
-	var fileID string
-
-	// 对于图片文件，使用NewPhoto发送以确保在Telegram中正确显示
-	if contentType == "image/jpeg" || contentType == "image/jpg" || contentType == "image/png" || contentType == "image/webp" {
-		photoMsg := tgbotapi.NewPhoto(global.AppConfig.Telegram.ChatID, tgbotapi.FilePath(tempFile.Name()))
-		message, err = global.Bot.Send(photoMsg)
-		if err != nil {
-			sendJSONError(w, "上传到存储服务失败", http.StatusInternalServerError)
-			return
-		}
-		// 获取最大尺寸的照片文件ID
-		if len(message.Photo) > 0 {
-			fileID = message.Photo[len(message.Photo)-1].FileID
-		}
-	} else {
-		// 对于GIF等其他格式，仍使用Document方式
-		docMsg := tgbotapi.NewDocument(global.AppConfig.Telegram.ChatID, tgbotapi.FilePath(tempFile.Name()))
-		message, err = global.Bot.Send(docMsg)
-		if err != nil {
-			sendJSONError(w, "上传到存储服务失败", http.StatusInternalServerError)
-			return
-		}
-		fileID = message.Document.FileID
-	}
-	telegramURL, err := global.Bot.GetFileDirectURL(fileID)
-	if err != nil {
-		sendJSONError(w, "获取文件URL失败", http.StatusInternalServerError)
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT proxy_url, filename, content_type, upload_time, is_active, is_private,
+				width, height, blurhash, dominant_color, duration, password_hash IS NOT NULL,
+				(expires_at IS NOT NULL AND expires_at <= datetime('now')) OR (COALESCE(max_views, 0) > 0 AND view_count >= max_views),
+				expires_at IS NOT NULL OR COALESCE(max_views, 0) > 0
+			FROM images
+			WHERE `+clause,
+			args...,
+		).Scan(&proxyURL, &meta.Filename, &meta.ContentType, &meta.UploadTime, &isActive, &isPrivate,
+			&meta.Width, &meta.Height, &blurHash, &dominantColor, &meta.Duration, &hasPassword,
+			&isExpired, &isLimited)
+	})
+	// 私有或有密码的图片的占位信息同样不对外公开，已过期或访问次数用尽的图片与 /file 一样视为不存在
+	if err != nil || !isActive || isPrivate || hasPassword || isExpired {
+		sendJSONError(w, "图片不存在", http.StatusNotFound)
 		return
 	}
 
-	// 生成公开URL
-	proxyUUID := uuid.New().String()
-	proxyURL := fmt.Sprintf("/file/%s%s", proxyUUID, fileExt)
+	meta.URL = fmt.Sprintf("%s://%s%s", requestScheme(r), r.Host, proxyURL)
+	meta.BlurHash = blurHash.String
+	meta.DominantColor = dominantColor.String
 
-	// 构建完整URL
-	var scheme string
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
+	// 元数据不会变化，允许客户端长期缓存；会过期的图片不缓存，过期后立即返回 404
+	if isLimited {
+		w.Header().Set("Cache-Control", "no-store")
 	} else {
-		scheme = "http"
-	}
-	fullURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, proxyURL)
-
-	// 存储记录到数据库
-	uploadTime := time.Now().Format(time.RFC3339)
-	err = db.WithDBTimeout(func(ctx context.Context) error {
-		stmt, err := global.DB.PrepareContext(ctx, `
-			INSERT INTO images (
-				telegram_url, 
-				proxy_url, 
-				ip_address, 
-				user_agent, 
-				filename,
-				content_type,
-				file_id,
-				upload_time
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-		`)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := stmt.Close(); cerr != nil {
-				logger.Error("[%s] failed to close statement: %v", requestID, cerr)
-			}
-		}()
-
-		_, err = stmt.ExecContext(ctx,
-			telegramURL,
-			proxyURL,
-			ipAddress,
-			userAgent,
-			filename,
-			contentType,
-			fileID,
-			uploadTime,
-		)
-		return err
-	})
-
-	if err != nil {
-		logger.Error("数据库插入失败: %v", err)
-		sendJSONError(w, "保存记录失败", http.StatusInternalServerError)
-		return
-	}
-
-	// 返回成功响应
-	imageResponse := ImageResponse{
-		URL:         fullURL,
-		Filename:    filename,
-		ContentType: contentType,
-		Size:        header.Size,
-		UploadTime:  uploadTime,
+		w.Header().Set("Cache-Control", "public, max-age=86400")
 	}
-
-	response := APIResponse{
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(APIResponse{
 		Success: true,
-		Message: "上传成功",
-		Data:    imageResponse,
+		Data:    meta,
+	}); err != nil {
+		logger.Error("failed to write image metadata response: %v", err)
 	}
+}
 
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		logger.Error("[%s] failed to write JSON response: %v", requestID, err)
+// requestScheme 根据 TLS 状态和反向代理头判断请求协议
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
 	}
+	return "http"
 }
 
 // sendJSONError 发送JSON格式的错误响应
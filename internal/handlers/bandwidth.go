@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hosting/internal/bandwidth"
+	"hosting/internal/global"
+	"hosting/internal/utils"
+)
+
+// 带宽限制相关的共享状态，首次使用时根据配置初始化
+var (
+	bandwidthOnce   sync.Once
+	globalLimiter   *bandwidth.Limiter
+	perIPLimiters   *bandwidth.Group
+	imageDailyUsage *bandwidth.DailyCounter
+	trustedProxies  []*net.IPNet
+	trustUnixPeers  bool // trustedProxies 中包含 "unix"，信任通过 unix 套接字连接的反向代理
+)
+
+// initBandwidth 根据配置创建限速器，未配置的限制保持为 nil
+func initBandwidth() {
+	bandwidthOnce.Do(func() {
+		cfg := global.AppConfig.Bandwidth
+		if cfg.GlobalKBps > 0 {
+			globalLimiter = bandwidth.NewLimiter(int64(cfg.GlobalKBps) * 1024)
+		}
+		if cfg.PerIPKBps > 0 {
+			perIPLimiters = bandwidth.NewGroup(int64(cfg.PerIPKBps) * 1024)
+		}
+		if cfg.ImageDailyMB > 0 {
+			imageDailyUsage = bandwidth.NewDailyCounter()
+		}
+		trustedProxies, trustUnixPeers = parseTrustedProxies(global.AppConfig.Security.TrustedProxies)
+	})
+}
+
+// parseTrustedProxies 解析可信代理列表，单个地址视为只包含该地址的网段，无效的项会被忽略
+// 特殊值 "unix" 表示信任通过 unix 套接字连接的对端，这类连接没有 IP 地址
+func parseTrustedProxies(entries []string) ([]*net.IPNet, bool) {
+	var networks []*net.IPNet
+	unix := false
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "unix" {
+			unix = true
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Printf("Invalid trusted proxy %q, ignored", entry)
+				continue
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Invalid trusted proxy %q, ignored", entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks, unix
+}
+
+// isTrustedProxy 地址是否属于配置的可信代理
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnixConn 请求是否通过 unix 套接字到达
+func isUnixConn(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// clientIP 返回客户端 IP，默认使用连接的来源地址
+// 只有请求来自可信代理时才读取 X-Forwarded-For，从右往左跳过可信代理，取第一个不可信的地址，避免客户端伪造
+func clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remote = host
+	}
+	if !isTrustedProxy(remote) && !(trustUnixPeers && isUnixConn(r)) {
+		return remote
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := utils.ValidateIPAddress(strings.TrimSpace(hops[i]))
+		if ip == "unknown" {
+			break
+		}
+		if !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return remote
+}
+
+// imageTrafficExceeded 检查图片今天的流量是否已超过每日上限，超过时返回 429
+func imageTrafficExceeded(w http.ResponseWriter, imageID int) bool {
+	initBandwidth()
+	if imageDailyUsage == nil {
+		return false
+	}
+
+	limit := int64(global.AppConfig.Bandwidth.ImageDailyMB) * 1024 * 1024
+	if imageDailyUsage.Used(imageID) < limit {
+		return false
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("Expires")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(bandwidth.UntilReset().Seconds()))))
+	http.Error(w, "Daily bandwidth limit exceeded for this image", http.StatusTooManyRequests)
+	return true
+}
+
+// throttleWriter 按配置的带宽限制包装响应，并统计图片的流量
+func throttleWriter(w http.ResponseWriter, r *http.Request, imageID int) io.Writer {
+	initBandwidth()
+	if globalLimiter == nil && perIPLimiters == nil && imageDailyUsage == nil {
+		return w
+	}
+
+	var ipLimiter *bandwidth.Limiter
+	if perIPLimiters != nil {
+		ipLimiter = perIPLimiters.Get(clientIP(r))
+	}
+
+	if ipLimiter != nil || globalLimiter != nil {
+		// 限速后传输时间可能超过服务器的写超时，取消本次响应的写超时
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("failed to clear write deadline: %v", err)
+		}
+	}
+
+	var onWrite func(n int)
+	if imageDailyUsage != nil {
+		onWrite = func(n int) {
+			imageDailyUsage.Add(imageID, int64(n))
+		}
+	}
+	return bandwidth.NewWriter(r.Context(), w, onWrite, ipLimiter, globalLimiter)
+}
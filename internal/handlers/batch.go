@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/utils"
+)
+
+// 批量上传的默认限制
+const (
+	defaultBatchMaxFiles     = 10
+	defaultBatchMaxTotalSize = 100 // MB
+	// batchReadTimeout 读取整个批量上传请求体的最长时间
+	batchReadTimeout = 10 * time.Minute
+)
+
+// batchFileResult 批量上传中单个文件的处理结果
+type batchFileResult struct {
+	Index    int            `json:"index"` // 文件在请求中的顺序，从 0 开始
+	Filename string         `json:"filename"`
+	Success  bool           `json:"success"`
+	Status   int            `json:"status"` // 单独上传该文件时对应的 HTTP 状态码
+	Message  string         `json:"message,omitempty"`
+	Data     *ImageResponse `json:"data,omitempty"`
+}
+
+// batchUploadResponse 批量上传的汇总结果
+type batchUploadResponse struct {
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []batchFileResult `json:"results"`
+}
+
+// batchMaxFiles 单次请求最多上传的文件数
+func batchMaxFiles() int {
+	if n := global.AppConfig.Upload.Batch.MaxFiles; n > 0 {
+		return n
+	}
+	return defaultBatchMaxFiles
+}
+
+// batchMaxBytes 单次请求的请求体大小上限，不小于单个文件的大小限制
+func batchMaxBytes() int64 {
+	total := global.AppConfig.Upload.Batch.MaxTotalSize
+	if total <= 0 {
+		total = defaultBatchMaxTotalSize
+	}
+	maxBytes := int64(total) * 1024 * 1024
+	if maxSize := maxUploadSize(); maxBytes < maxSize {
+		maxBytes = maxSize
+	}
+	return maxBytes
+}
+
+// acquireUploadSlot 占用一个并发上传名额，成功时返回释放函数
+// wait 为 false 时没有空闲名额立即失败；为 true 时一直等待到 ctx 结束，用于已经接收完整的断点续传文件
+func acquireUploadSlot(ctx context.Context, wait bool) (func(), bool) {
+	release := func() { <-global.UploadSemaphore }
+	if !wait {
+		select {
+		case global.UploadSemaphore <- struct{}{}:
+			return release, true
+		default:
+			return nil, false
+		}
+	}
+	select {
+	case global.UploadSemaphore <- struct{}{}:
+		return release, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// extendUploadDeadlines 延长本次请求的读写超时，服务器的 ReadTimeout/WriteTimeout 针对普通请求，
+// 一次上传多个文件时读取请求体和逐个保存都可能超过；read 为 0 时只延长写入超时
+func extendUploadDeadlines(w http.ResponseWriter, requestID string, read, write time.Duration) {
+	rc := http.NewResponseController(w)
+	now := time.Now()
+	if read > 0 {
+		if err := rc.SetReadDeadline(now.Add(read)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			logger.Warn("[%s] 设置读取超时失败: %v", requestID, err)
+		}
+	}
+	if err := rc.SetWriteDeadline(now.Add(write)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Warn("[%s] 设置写入超时失败: %v", requestID, err)
+	}
+}
+
+// saveDeadline 逐个保存 files 个文件并写入响应所需的时间
+func saveDeadline(files int) time.Duration {
+	return time.Duration(files+1) * global.UploadTimeout
+}
+
+// HandleAPIUploadBatch 在一个 multipart 请求中上传多个文件
+// 整个请求占用一个并发名额，每个文件单独校验大小和类型，部分文件失败不影响其他文件，结果按提交顺序逐个返回
+func HandleAPIUploadBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	requestID := uuid.New().String()
+	logger.Debug("开始处理批量上传请求: %s", requestID)
+
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("[%s] 批量上传处理中发生panic: %v", requestID, err)
+			sendJSONError(w, "服务器内部错误", http.StatusInternalServerError)
+		}
+	}()
+
+	// 读取请求体之前占用并发名额，限制同时写入本地副本的请求数
+	release, ok := acquireUploadSlot(r.Context(), false)
+	if !ok {
+		sendJSONError(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	extendUploadDeadlines(w, requestID, batchReadTimeout, batchReadTimeout)
+
+	// 整个请求体受总大小限制，每个文件边接收边写入本地副本，超过单个文件限制的只记录错误
+	maxSize := maxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, batchMaxBytes())
+	form, err := readMultipartForm(r, []string{"images", "image"}, batchMaxFiles(), maxSize)
+	if errors.Is(err, errTooManyFiles) {
+		sendJSONError(w, fmt.Sprintf("单次最多上传 %d 个文件", batchMaxFiles()), http.StatusBadRequest)
+		return
+	}
+	if isTooLarge(err) {
+		sendJSONError(w, fmt.Sprintf("请求总大小超过限制 (%dMB)", batchMaxBytes()/1024/1024), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Warn("[%s] 解析表单数据失败: %v", requestID, err)
+		sendJSONError(w, "无法解析表单数据", http.StatusBadRequest)
+		return
+	}
+	defer form.Close()
+
+	if len(form.Files) == 0 {
+		sendJSONError(w, "未找到上传文件，请通过 images 字段提交", http.StatusBadRequest)
+		return
+	}
+	// 同一个自定义链接名只能用于一个文件
+	if form.Options.Get("slug") != "" {
+		sendJSONError(w, "批量上传不支持自定义链接名", http.StatusBadRequest)
+		return
+	}
+	extendUploadDeadlines(w, requestID, 0, saveDeadline(len(form.Files)))
+
+	response := batchUploadResponse{
+		Total:   len(form.Files),
+		Results: make([]batchFileResult, 0, len(form.Files)),
+	}
+	for i, file := range form.Files {
+		result := batchFileResult{Index: i, Filename: utils.SanitizeFilename(file.Filename)}
+		imageResponse, reused, uploadErr := storeBatchFile(r, requestID, form, i)
+		if uploadErr != nil {
+			result.Status = uploadErr.Code
+			result.Message = uploadErr.Message
+			response.Failed++
+			logger.Warn("[%s] 批量上传第 %d 个文件失败: %s", requestID, i, uploadErr.Message)
+		} else {
+			result.Success = true
+			result.Status = http.StatusCreated
+			result.Message = "上传成功"
+			if reused {
+				result.Status = http.StatusOK
+				result.Message = "文件已存在，返回已有链接"
+			}
+			result.Data = imageResponse
+			response.Succeeded++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	// 至少一个文件成功时返回 200，逐个文件的结果见 results；全部失败时使用第一个文件的错误状态码
+	statusCode := http.StatusOK
+	if response.Succeeded == 0 {
+		statusCode = response.Results[0].Status
+	}
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(APIResponse{
+		Success: response.Failed == 0,
+		Message: fmt.Sprintf("上传完成：成功 %d 个，失败 %d 个", response.Succeeded, response.Failed),
+		Data:    response,
+	}); err != nil {
+		logger.Error("[%s] failed to write JSON response: %v", requestID, err)
+	}
+}
+
+// storeBatchFile 在单独的超时内保存批量上传中的第 i 个文件
+func storeBatchFile(r *http.Request, requestID string, form *streamedForm, i int) (*ImageResponse, bool, *uploadError) {
+	if err := form.Files[i].Err; err != nil {
+		return nil, false, &uploadError{Message: fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), Code: http.StatusBadRequest}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	upload := form.source(i)
+	defer upload.Close()
+
+	result, uploadErr := storeUpload(ctx, newUploadRequest(r, requestID, upload))
+	if uploadErr != nil {
+		return nil, false, uploadErr
+	}
+	return result.imageResponse(), result.Reused, nil
+}
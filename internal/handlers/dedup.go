@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+)
+
+// 重复文件处理方式
+const (
+	dedupReuse = "reuse" // 直接返回已有图片的链接
+	dedupLink  = "link"  // 复用已存储的文件，但生成新的访问链接
+	dedupOff   = "off"   // 关闭去重，每次都发送到 Telegram
+)
+
+// reusableExpr 判断已有图片的链接能否直接返回给其他上传者：私有、有密码或会过期的图片不可以
+const reusableExpr = "(is_private = 0 AND expires_at IS NULL AND max_views = 0 AND password_hash IS NULL)"
+
+// duplicateImage 已存在的相同内容图片
+type duplicateImage struct {
+	ProxyURL    string
+	TelegramURL string
+	FileID      string
+	ThumbFileID string // 视频封面帧
+	UploadTime  string
+	Reusable    bool // 是否可以直接返回该图片的链接
+}
+
+// dedupMode 返回当前生效的去重方式，未配置或配置无效时默认为 reuse
+func dedupMode() string {
+	switch global.AppConfig.Upload.DedupMode {
+	case dedupLink, dedupOff:
+		return global.AppConfig.Upload.DedupMode
+	default:
+		return dedupReuse
+	}
+}
+
+// findDuplicateImage 根据文件哈希查找仍处于活跃状态的相同图片，未找到时返回 nil
+func findDuplicateImage(fileHash string) (*duplicateImage, error) {
+	var dup duplicateImage
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, `
+			SELECT proxy_url, telegram_url, file_id, COALESCE(thumb_file_id, ''), upload_time, `+reusableExpr+` AS reusable
+			FROM images
+			WHERE file_hash = ? AND is_active = 1
+			ORDER BY reusable DESC, id ASC
+			LIMIT 1`,
+			fileHash,
+		).Scan(&dup.ProxyURL, &dup.TelegramURL, &dup.FileID, &dup.ThumbFileID, &dup.UploadTime, &dup.Reusable)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dup, nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+
+	"hosting/internal/imaging"
+)
+
+// maxFeaturePixels 提取特征时允许解码的最大像素数，约为 8000x5000，解码后占用约 160MB 内存
+const maxFeaturePixels = 40_000_000
+
+// imageFeatures 上传时从图片中提取的特征信息
+type imageFeatures struct {
+	Width         int
+	Height        int
+	PHash         string  // 感知哈希，用于查找相似图片
+	BlurHash      string  // 加载占位图
+	DominantColor string  // 主色调，格式为 #rrggbb
+	Duration      float64 // 视频时长（秒）
+}
+
+// extractImageFeatures 提取图片尺寸、感知哈希、BlurHash 和主色调
+// 无法解码的格式（如 WebP）只返回能从文件头读取到的尺寸，视频只返回尺寸和时长
+func extractImageFeatures(path, contentType string) imageFeatures {
+	var features imageFeatures
+	if imaging.IsVideo(contentType) {
+		info, err := imaging.ReadVideoInfo(path, contentType)
+		if err != nil {
+			log.Printf("failed to read video metadata: %v", err)
+			return features
+		}
+		features.Width, features.Height, features.Duration = info.Width, info.Height, info.Duration
+		return features
+	}
+
+	width, height, err := imaging.DecodeConfigFile(path)
+	if err == nil {
+		features.Width, features.Height = width, height
+	}
+
+	if !imaging.CanDecode(contentType) {
+		return features
+	}
+	// 解码会按像素数分配内存，读不到尺寸或尺寸过大时不计算特征
+	if err != nil || int64(width)*int64(height) > maxFeaturePixels {
+		log.Printf("skipped feature extraction for %dx%d image", width, height)
+		return features
+	}
+	img, err := imaging.DecodeFile(path)
+	if err != nil {
+		log.Printf("failed to decode image for feature extraction: %v", err)
+		return features
+	}
+
+	features.PHash = imaging.FormatHash(imaging.DHash(img))
+	features.BlurHash = imaging.BlurHash(img)
+	features.DominantColor = imaging.DominantColor(img)
+	return features
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"mime"
+	"strings"
+
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/utils"
+)
+
+// maxExtensionLength 通用文件扩展名的最大长度（不含点）
+const maxExtensionLength = 10
+
+// genericContentTypes 文件头无法确定具体类型时 http.DetectContentType 返回的类型，此时参考扩展名
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+}
+
+// resolveContentType 根据文件头和原始文件名确定内容类型和保存时使用的扩展名
+// 优先识别支持的图片和视频格式；开启通用文件托管时，其他文件按 upload.files 中的允许和禁止列表判断
+func resolveContentType(header []byte, filename string) (string, string, bool) {
+	contentType := imaging.DetectContentType(header)
+	if fileExt, ok := utils.GetFileExtension(contentType); ok {
+		return contentType, fileExt, true
+	}
+
+	originalExt := utils.NormalizeFileExtension(filename)
+	for mimeType, ext := range global.AllowedMimeTypes {
+		if ext == originalExt {
+			return mimeType, ext, true
+		}
+	}
+
+	if !global.AppConfig.Upload.Files.Enabled {
+		return "", "", false
+	}
+
+	sniffed := baseMediaType(contentType)
+	fileExt := safeExtension(originalExt)
+	resolved := sniffed
+	if genericContentTypes[sniffed] && fileExt != "" {
+		// 文件头没有特征时使用扩展名对应的类型，例如 CSV、JSON
+		if byExt := baseMediaType(mime.TypeByExtension(fileExt)); byExt != "" {
+			resolved = byExt
+		}
+	}
+
+	// 识别出的类型、扩展名对应的类型和扩展名本身都不能命中禁止列表
+	if !fileTypeAllowed(sniffed, fileExt) || !fileTypeAllowed(resolved, fileExt) {
+		return "", "", false
+	}
+	return resolved, fileExt, true
+}
+
+// fileTypeAllowed 按 upload.files 的配置判断文件是否允许上传
+// 禁止列表优先；允许列表为空时允许所有未被禁止的类型
+func fileTypeAllowed(contentType, ext string) bool {
+	files := global.AppConfig.Upload.Files
+	for _, pattern := range files.Deny {
+		if matchFileType(pattern, contentType, ext) {
+			return false
+		}
+	}
+	if len(files.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range files.Allow {
+		if matchFileType(pattern, contentType, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFileType 匹配单条规则：以 . 开头的为扩展名，"audio/*" 匹配整个大类，其他为完整的 MIME 类型
+func matchFileType(pattern, contentType, ext string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	switch {
+	case pattern == "":
+		return false
+	case strings.HasPrefix(pattern, "."):
+		return pattern == ext
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == contentType
+	}
+}
+
+// baseMediaType 去掉 MIME 类型中的参数部分，如 "text/plain; charset=utf-8" 返回 "text/plain"
+func baseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// safeExtension 只保留由小写字母和数字组成的扩展名，避免在链接中出现特殊字符
+func safeExtension(ext string) string {
+	name := strings.TrimPrefix(ext, ".")
+	if name == "" || len(name) > maxExtensionLength {
+		return ""
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return ""
+		}
+	}
+	return "." + name
+}
+
+// servesInline 判断文件能否在浏览器中直接打开
+// 图片、音视频、纯文本和 PDF 直接显示，HTML、脚本等可能执行代码的类型一律作为附件下载
+func servesInline(contentType string) bool {
+	if _, ok := global.AllowedMimeTypes[contentType]; ok {
+		return true
+	}
+	switch {
+	case strings.HasPrefix(contentType, "audio/"), strings.HasPrefix(contentType, "video/"):
+		return true
+	case contentType == "text/plain", contentType == "application/pdf":
+		return true
+	}
+	return false
+}
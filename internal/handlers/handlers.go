@@ -3,15 +3,18 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
@@ -57,12 +60,18 @@ func HandleHome(w http.ResponseWriter, r *http.Request) {
 		MaxFileSize           int
 		RequireLoginForUpload bool
 		IsLoggedIn            bool
+		FileHosting           bool
+		RemoteUpload          bool
+		MaxBatchFiles         int
 	}{
 		Title:                 utils.GetPageTitle("图床"),
 		Favicon:               global.AppConfig.Site.Favicon,
 		MaxFileSize:           global.AppConfig.Site.MaxFileSize,
 		RequireLoginForUpload: global.AppConfig.Security.RequireLoginForUpload,
 		IsLoggedIn:            isLoggedIn,
+		FileHosting:           global.AppConfig.Upload.Files.Enabled,
+		RemoteUpload:          remoteEnabled(),
+		MaxBatchFiles:         batchMaxFiles(),
 	}
 	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,7 +79,16 @@ func HandleHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandleUpload 处理图片上传
+// webUploadResult 网页上传中单个文件的结果
+type webUploadResult struct {
+	Filename string
+	URL      string
+	Links    []uploadLink
+	Error    string
+	Code     int
+}
+
+// HandleUpload 处理图片上传，一次可以选择多个文件，每个文件单独保存并显示各自的结果
 func HandleUpload(w http.ResponseWriter, r *http.Request) {
 	// 添加请求追踪ID用于日志
 	requestID := uuid.New().String()
@@ -83,24 +101,27 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// 使用context控制超时
-	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
-	defer cancel()
-	r = r.WithContext(ctx)
-
-	// 并发控制使用channel代替mutex
-	select {
-	case global.UploadSemaphore <- struct{}{}:
-		defer func() { <-global.UploadSemaphore }()
-	default:
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+	// 读取请求体之前占用并发名额，整个请求中的文件逐个保存
+	release, ok := acquireUploadSlot(r.Context(), false)
+	if !ok {
+		http.Error(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
 		return
 	}
+	defer release()
+	extendUploadDeadlines(w, requestID, batchReadTimeout, batchReadTimeout)
 
-	maxSize := int64(global.AppConfig.Site.MaxFileSize * 1024 * 1024)
-	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
-
-	file, header, err := r.FormFile("image")
+	// 请求体按批量上传的总大小限制，表单流式读取，每个文件边接收边写入本地副本
+	maxSize := maxUploadSize()
+	r.Body = http.MaxBytesReader(w, r.Body, batchMaxBytes())
+	form, err := readMultipartForm(r, []string{"image"}, batchMaxFiles(), maxSize)
+	if errors.Is(err, errTooManyFiles) {
+		http.Error(w, fmt.Sprintf("单次最多上传 %d 个文件", batchMaxFiles()), http.StatusBadRequest)
+		return
+	}
+	if isTooLarge(err) {
+		http.Error(w, fmt.Sprintf("Request size exceeds limit (%dMB)", batchMaxBytes()/1024/1024), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		handleError(w, &AppError{
 			Error:   err,
@@ -109,156 +130,71 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			log.Printf("[%s] failed to close uploaded file: %v", requestID, cerr)
-		}
-	}()
-
-	if header.Size > maxSize {
-		http.Error(w, "File size exceeds limit", http.StatusBadRequest)
-		return
-	}
+	defer form.Close()
+	extendUploadDeadlines(w, requestID, 0, saveDeadline(max(len(form.Files), 1)))
 
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if _, err = file.Seek(0, 0); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	contentType := http.DetectContentType(buffer)
-	fileExt, ok := utils.GetFileExtension(contentType)
-	if !ok {
-		originalExt := utils.NormalizeFileExtension(header.Filename)
-		for mime, ext := range global.AllowedMimeTypes {
-			if ext == originalExt {
-				fileExt = ext
-				contentType = mime
-				ok = true
-				break
-			}
-		}
-
-		if !ok {
-			http.Error(w, "Unsupported file type. Only JPG/JPEG, PNG, GIF and WebP are allowed", http.StatusBadRequest)
+	var results []webUploadResult
+	// 填写了图片链接时从远程导入，否则读取上传的文件
+	if rawURL := strings.TrimSpace(form.Options.Get("url")); rawURL != "" {
+		if !remoteEnabled() {
+			http.Error(w, "Import from URL is disabled", http.StatusForbidden)
 			return
 		}
-	}
-
-	ipAddress := utils.ValidateIPAddress(r.RemoteAddr)
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ipAddress = utils.ValidateIPAddress(forwardedFor)
-	}
-	userAgent := utils.SanitizeUserAgent(r.Header.Get("User-Agent"))
-	filename := utils.SanitizeFilename(header.Filename)
-
-	tempFile, err := os.CreateTemp("", "upload-*"+fileExt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer func() {
-		if cerr := os.Remove(tempFile.Name()); cerr != nil {
-			log.Printf("[%s] failed to remove temp file %s: %v", requestID, tempFile.Name(), cerr)
-		}
-	}()
-	defer func() {
-		if cerr := tempFile.Close(); cerr != nil {
-			log.Printf("[%s] failed to close temp file %s: %v", requestID, tempFile.Name(), cerr)
-		}
-	}()
-
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 根据文件类型选择发送方式
-	var message tgbotapi.Message
-	var fileID string
-
-	// 对于图片文件（JPG/PNG/WebP），使用 NewPhoto 发送
-	// 注意：Telegram 会将动态 WebP 转为静态图片，这是 Telegram 的限制
-	if contentType == "image/jpeg" || contentType == "image/jpg" || contentType == "image/png" || contentType == "image/webp" {
-		photoMsg := tgbotapi.NewPhoto(global.AppConfig.Telegram.ChatID, tgbotapi.FilePath(tempFile.Name()))
-		message, err = global.Bot.Send(photoMsg)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		result := processWebUpload(r, requestID, func(ctx context.Context) (*uploadSource, *uploadError) {
+			upload, err := remoteFileSource(ctx, rawURL)
+			if err != nil {
+				message, code := remoteErrorResponse(err)
+				log.Printf("[%s] failed to fetch %s: %v", requestID, remoteSourceLabel(rawURL), err)
+				return nil, &uploadError{Message: message, Code: code}
+			}
+			upload.Options = form.Options
+			return upload, nil
+		})
+		results = append(results, result)
+	} else {
+		if len(form.Files) == 0 {
+			handleError(w, &AppError{
+				Error:   http.ErrMissingFile,
+				Message: "无法读取上传文件",
+				Code:    http.StatusBadRequest,
+			})
 			return
 		}
-		// 获取最大尺寸的照片文件ID
-		if len(message.Photo) > 0 {
-			fileID = message.Photo[len(message.Photo)-1].FileID
-		}
-	} else {
-		// 对于 GIF，使用 Document 方式
-		docMsg := tgbotapi.NewDocument(global.AppConfig.Telegram.ChatID, tgbotapi.FilePath(tempFile.Name()))
-		message, err = global.Bot.Send(docMsg)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if len(form.Files) > 1 && form.Options.Get("slug") != "" {
+			http.Error(w, "批量上传不支持自定义链接名", http.StatusBadRequest)
 			return
 		}
-		fileID = message.Document.FileID
-	}
-	telegramURL, err := global.Bot.GetFileDirectURL(fileID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		for i, file := range form.Files {
+			result := processWebUpload(r, requestID, func(context.Context) (*uploadSource, *uploadError) {
+				if file.Err != nil {
+					return nil, &uploadError{Message: "File size exceeds limit", Code: http.StatusBadRequest}
+				}
+				return form.source(i), nil
+			})
+			if result.Filename == "" {
+				result.Filename = utils.SanitizeFilename(file.Filename)
+			}
+			results = append(results, result)
+		}
 	}
 
-	proxyUUID := uuid.New().String()
-	proxyURL := fmt.Sprintf("/file/%s%s", proxyUUID, fileExt)
-
-	var scheme string
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	} else {
-		scheme = "http"
-	}
-	fullURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, proxyURL)
-
-	err = db.WithDBTimeout(func(ctx context.Context) error {
-		stmt, err := global.DB.PrepareContext(ctx, `
-			INSERT INTO images (
-				telegram_url, 
-				proxy_url, 
-				ip_address, 
-				user_agent, 
-				filename,
-				content_type,
-				file_id
-			) VALUES (?, ?, ?, ?, ?, ?, ?)
-		`)
-		if err != nil {
-			return err
+	// 没有任何文件上传成功时返回错误，多个文件时列出每个文件的原因
+	succeeded := 0
+	for _, result := range results {
+		if result.Error == "" {
+			succeeded++
 		}
-		defer func() {
-			if cerr := stmt.Close(); cerr != nil {
-				log.Printf("[%s] failed to close statement: %v", requestID, cerr)
+	}
+	if succeeded == 0 {
+		message := results[0].Error
+		if len(results) > 1 {
+			failures := make([]string, 0, len(results))
+			for _, result := range results {
+				failures = append(failures, result.Filename+": "+result.Error)
 			}
-		}()
-
-		_, err = stmt.ExecContext(ctx,
-			telegramURL,
-			proxyURL,
-			ipAddress,
-			userAgent,
-			filename,
-			contentType,
-			fileID, // 添加 fileID
-		)
-		return err
-	})
-
-	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Printf("Error executing statement: %v", err)
+			message = strings.Join(failures, "\n")
+		}
+		http.Error(w, message, results[0].Code)
 		return
 	}
 
@@ -268,15 +204,15 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data := struct {
-		Title    string
-		Favicon  string
-		URL      string
-		Filename string
+		Title   string
+		Favicon string
+		Results []webUploadResult
+		Failed  int
 	}{
-		Title:    utils.GetPageTitle("上传"),
-		Favicon:  global.AppConfig.Site.Favicon,
-		URL:      fullURL,
-		Filename: filename,
+		Title:   utils.GetPageTitle("上传"),
+		Favicon: global.AppConfig.Site.Favicon,
+		Results: results,
+		Failed:  len(results) - succeeded,
 	}
 	if err := t.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -284,19 +220,47 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// processWebUpload 在单独的超时内取得并保存一个文件，调用方负责占用并发名额
+func processWebUpload(r *http.Request, requestID string, open func(ctx context.Context) (*uploadSource, *uploadError)) webUploadResult {
+	// 使用context控制超时
+	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	upload, uploadErr := open(ctx)
+	if uploadErr != nil {
+		return webUploadResult{Error: uploadErr.Message, Code: uploadErr.Code}
+	}
+	defer upload.Close()
+
+	result, uploadErr := storeUpload(ctx, newUploadRequest(r, requestID, upload))
+	if uploadErr != nil {
+		log.Printf("[%s] upload failed: %s", requestID, uploadErr.Message)
+		return webUploadResult{Filename: utils.SanitizeFilename(upload.Filename), Error: uploadErr.Message, Code: uploadErr.Code}
+	}
+	return webUploadResult{Filename: result.Filename, URL: result.URL, Links: result.links()}
+}
+
 func GetTelegramFileURL(fileID string) (string, error) {
 	return global.Bot.GetFileDirectURL(fileID)
 }
 
 func HandleImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	// /file/{uuid} 支持 UUID 和自定义链接名，/i/{code} 为短链接
 	uuid := vars["uuid"]
+	if uuid == "" {
+		uuid = vars["code"]
+	}
+
+	// ?download=1 或 /download/{uuid} 以附件形式下载
+	isDownload := strings.HasPrefix(r.URL.Path, "/download/") || isTruthy(r.URL.Query().Get("download"))
 
 	// 设置 CORS 头部，允许其他网站嵌入图片
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Range")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, Content-Disposition")
 
 	// 处理 OPTIONS 预检请求
 	if r.Method == "OPTIONS" {
@@ -307,17 +271,27 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
 	w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
 
-	var telegramURL, contentType string
-	var isActive bool
-	var fileID string
+	var imageID int
+	var telegramURL, contentType, filename string
+	var isActive, isPrivate, isExpired bool
+	var fileID, hotlinkPolicy string
+	var expiry, passwordHash sql.NullString
+	var maxViews, viewCount int
 
+	clause, args, ok := imageLookupClause(uuid)
+	if !ok {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
 	err := db.WithDBTimeout(func(ctx context.Context) error {
 		return global.DB.QueryRowContext(ctx, `
-            SELECT telegram_url, content_type, is_active, file_id 
+            SELECT id, telegram_url, content_type, filename, is_active, file_id, is_private, hotlink_policy,
+                expires_at, max_views, view_count, (expires_at IS NOT NULL AND expires_at <= datetime('now')), password_hash 
             FROM images 
-            WHERE proxy_url LIKE ?`,
-			fmt.Sprintf("/file/%s%%", uuid),
-		).Scan(&telegramURL, &contentType, &isActive, &fileID)
+            WHERE `+clause,
+			args...,
+		).Scan(&imageID, &telegramURL, &contentType, &filename, &isActive, &fileID, &isPrivate, &hotlinkPolicy,
+			&expiry, &maxViews, &viewCount, &isExpired, &passwordHash)
 	})
 
 	if err != nil {
@@ -326,32 +300,126 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !isActive {
-		// 尝试读取占位图片
-		deletedImage, err := os.ReadFile("static/deleted.jpg")
-		if err != nil {
-			// 降级处理：占位图片不存在时返回错误
-			log.Printf("Failed to read deleted placeholder image: %v", err)
-			http.Error(w, "Image has been deleted", http.StatusGone)
+		serveDeletedPlaceholder(w, r, "deleted")
+		// 记录访问已删除图片的日志
+		log.Printf("Served deleted placeholder for UUID: %s", uuid)
+		return
+	}
+
+	// 超过有效期的图片不再提供访问，后台任务会将其标记为已删除
+	if isExpired {
+		serveDeletedPlaceholder(w, r, "expired")
+		return
+	}
+
+	// 防盗链：检查 Referer/Origin 是否来自允许的站点
+	if hotlinkProtected(hotlinkPolicy) {
+		// 同一图片对不同来源的响应不同，共享缓存需要区分 Referer
+		w.Header().Add("Vary", "Referer, Origin")
+		if !hotlinkAllowed(r) {
+			serveHotlinkBlocked(w, r)
 			return
 		}
+	}
 
-		// 设置响应头
-		w.Header().Set("Content-Type", "image/jpeg")
-		w.Header().Set("Content-Length", strconv.Itoa(len(deletedImage)))
-		w.Header().Set("Cache-Control", "public, max-age=86400") // 缓存1天
-		w.Header().Set("X-Image-Status", "deleted")              // 标识图片状态
+	// 私有图片只能通过未过期的签名链接访问
+	var signedUntil time.Time
+	if isPrivate {
+		query := r.URL.Query()
+		expiresAt, ok := utils.VerifySignedPath(r.URL.Path, query.Get("exp"), query.Get("sig"))
+		if !ok && isDownload {
+			// 下载地址沿用原始链接的签名
+			expiresAt, ok = utils.VerifySignedPath("/file/"+uuid, query.Get("exp"), query.Get("sig"))
+			if !ok {
+				expiresAt, ok = utils.VerifySignedPath("/i/"+uuid, query.Get("exp"), query.Get("sig"))
+			}
+		}
+		if !ok {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Del("Expires")
+			http.Error(w, "Link expired or invalid signature", http.StatusForbidden)
+			return
+		}
+		// 缓存时间不超过签名的剩余有效期，且不允许共享缓存
+		maxAge := int(time.Until(expiresAt).Seconds())
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+		w.Header().Set("Expires", expiresAt.UTC().Format(http.TimeFormat))
+		signedUntil = expiresAt
+	}
 
-		// 返回占位图片
-		w.WriteHeader(http.StatusOK)
-		if _, werr := w.Write(deletedImage); werr != nil {
-			log.Printf("failed to write deleted placeholder image: %v", werr)
+	// 有密码的图片需要先输入密码或携带访问令牌
+	if passwordHash.Valid {
+		w.Header().Add("Vary", "Cookie")
+		if !authorizeImagePassword(w, r, imageID, passwordHash.String) {
+			return
 		}
+		if !isPrivate {
+			w.Header().Set("Cache-Control", "private, max-age=86400")
+		}
+	}
 
-		// 记录访问已删除图片的日志
-		log.Printf("Served deleted placeholder for UUID: %s", uuid)
+	// 单张图片每日流量超限后暂停访问
+	if imageTrafficExceeded(w, imageID) {
 		return
 	}
 
+	// 设置了有效期的图片，缓存时间不超过剩余有效期
+	if expiry.Valid {
+		if t, err := time.Parse(time.DateTime, expiry.String); err == nil && (signedUntil.IsZero() || t.Before(signedUntil)) {
+			if remaining := time.Until(t); remaining < 365*24*time.Hour {
+				w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheScope(isPrivate || passwordHash.Valid), int(remaining.Seconds())))
+				w.Header().Set("Expires", t.Format(http.TimeFormat))
+			}
+		}
+	}
+
+	// 限制访问次数的图片：原子地占用一次访问额度，额度用完后不再提供访问
+	viewIncrement := 1
+	switch {
+	case r.Method == http.MethodHead:
+		// HEAD 请求不返回内容，不计数，但额度用完后同样不再提供
+		viewIncrement = 0
+		if maxViews > 0 && viewCount >= maxViews {
+			serveDeletedPlaceholder(w, r, "expired")
+			return
+		}
+	case isRangeContinuation(r):
+		// 视频播放和拖动进度条会发出多个 Range 请求，只有从头开始的请求计为一次访问
+		// 限制访问次数的图片，后续的 Range 请求需要携带计数时发放的令牌，否则同样占用一次额度
+		if maxViews == 0 || hasViewGrant(r, imageID) {
+			viewIncrement = 0
+		}
+	}
+	if maxViews > 0 && viewIncrement > 0 {
+		var claimed int64
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			result, err := global.DB.ExecContext(ctx,
+				"UPDATE images SET view_count = view_count + 1 WHERE id = ? AND view_count < max_views",
+				imageID)
+			if err != nil {
+				return err
+			}
+			claimed, err = result.RowsAffected()
+			return err
+		})
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Printf("Failed to claim view for image %d: %v", imageID, err)
+			return
+		}
+		if claimed == 0 {
+			serveDeletedPlaceholder(w, r, "expired")
+			return
+		}
+		viewIncrement = 0
+		grantView(w, imageID)
+	}
+	if maxViews > 0 {
+		// 每次访问都需要计数，不允许任何缓存
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Del("Expires")
+	}
+
 	// 检查URL缓存
 	global.URLCacheMux.RLock()
 	cache, exists := global.URLCache[telegramURL]
@@ -392,8 +460,8 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 
 			// 同时更新 telegram_url 和 view_count
 			_, err = tx.ExecContext(ctx,
-				"UPDATE images SET telegram_url = ?, view_count = view_count + 1 WHERE proxy_url LIKE ?",
-				newURL, fmt.Sprintf("/file/%s%%", uuid))
+				"UPDATE images SET telegram_url = ?, view_count = view_count + ? WHERE id = ?",
+				newURL, viewIncrement, imageID)
 			if err != nil {
 				return err
 			}
@@ -408,25 +476,22 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 	} else {
 		currentURL = cache.URL
 
-		// 只更新访问计数
-		err = db.WithDBTimeout(func(ctx context.Context) error {
-			_, err := global.DB.ExecContext(ctx,
-				"UPDATE images SET view_count = view_count + 1 WHERE proxy_url LIKE ?",
-				fmt.Sprintf("/file/%s%%", uuid))
-			return err
-		})
+		// 只更新访问计数（限制访问次数的图片已在前面计数）
+		if viewIncrement > 0 {
+			err = db.WithDBTimeout(func(ctx context.Context) error {
+				_, err := global.DB.ExecContext(ctx,
+					"UPDATE images SET view_count = view_count + 1 WHERE id = ?",
+					imageID)
+				return err
+			})
 
-		if err != nil {
-			log.Printf("Failed to update view count: %v", err)
-			// 继续处理请求，不返回错误给用户
+			if err != nil {
+				log.Printf("Failed to update view count: %v", err)
+				// 继续处理请求，不返回错误给用户
+			}
 		}
 	}
 
-	// 创建一个带超时的客户端
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
 	req, err := http.NewRequestWithContext(r.Context(), "GET", currentURL, nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -438,7 +503,7 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 		req.Header.Set("Range", rangeHeader)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := fileProxyClient.Do(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -486,12 +551,40 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 
 	// 设置响应头 - 必须在 WriteHeader 之前设置所有头部
 	w.Header().Set("Content-Type", actualContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if actualContentType == "image/svg+xml" {
+		// 直接打开 SVG 时禁止脚本和外部资源，作为上传时清理的额外保障
+		w.Header().Set("Content-Security-Policy", svgContentSecurityPolicy)
+	}
+
+	// 附带原始文件名，下载模式下浏览器直接保存文件
+	disposition := "inline"
+	if isDownload {
+		disposition = "attachment"
+	}
+	if !servesInline(actualContentType) {
+		// HTML、脚本等可能执行代码的文件只能下载，并禁止在本站源下运行
+		disposition = "attachment"
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition,
+		downloadFilename(filename, uuid, contentType, actualContentType)))
 
 	// 如果原始响应有内容长度，也设置它
 	if resp.ContentLength > 0 {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", resp.ContentLength))
 	}
 
+	// 请求范围超出文件长度时原样返回 416，让播放器重新定位
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if contentRange := resp.Header.Get("Content-Range"); contentRange != "" {
+			w.Header().Set("Content-Range", contentRange)
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
 	// 设置响应状态码（如果是 Range 请求则为 206）
 	if resp.StatusCode == 206 {
 		// 转发 Range 相关的响应头 - 在 WriteHeader 之前
@@ -514,12 +607,107 @@ func HandleImage(w http.ResponseWriter, r *http.Request) {
 
 	// 流式拷贝数据
 	buf := make([]byte, 32*1024) // 32KB 缓冲区
-	_, err = io.CopyBuffer(w, resp.Body, buf)
+	_, err = io.CopyBuffer(throttleWriter(w, r, imageID), resp.Body, buf)
 	if err != nil {
 		log.Printf("Error streaming file: %v", err)
 	}
 }
 
+// viewGrantTTL 计数后继续发出 Range 请求（如拖动视频进度）的有效期
+const viewGrantTTL = 30 * time.Minute
+
+// viewGrantCookieName 保存访问计数令牌的 Cookie 名称
+func viewGrantCookieName(imageID int) string {
+	return "view_" + strconv.Itoa(imageID)
+}
+
+// grantView 计数成功后发放令牌，之后一段时间内的 Range 请求不再重复计数
+func grantView(w http.ResponseWriter, imageID int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     viewGrantCookieName(imageID),
+		Value:    utils.UnlockToken(fmt.Sprintf("view\n%d", imageID), viewGrantTTL),
+		Path:     "/",
+		MaxAge:   int(viewGrantTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   !global.IsDevelopment,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// hasViewGrant 请求是否携带了有效的访问计数令牌
+func hasViewGrant(r *http.Request, imageID int) bool {
+	cookie, err := r.Cookie(viewGrantCookieName(imageID))
+	return err == nil && utils.VerifyUnlockToken(fmt.Sprintf("view\n%d", imageID), cookie.Value)
+}
+
+// isRangeContinuation 判断是否为不从文件开头读取的 Range 请求
+func isRangeContinuation(r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader != "" && !strings.HasPrefix(strings.TrimSpace(rangeHeader), "bytes=0-")
+}
+
+// fileProxyClient 从 Telegram 拉取文件的客户端，只限制等待响应头的时间
+// 响应体按带宽限制转发给访客，可能持续很久，不能设置整体超时
+var fileProxyClient = &http.Client{Transport: newFileProxyTransport()}
+
+// newFileProxyTransport 在默认 Transport 的基础上设置等待响应头的超时
+func newFileProxyTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return transport
+}
+
+// svgContentSecurityPolicy 返回 SVG 时使用的 CSP，只允许内联样式和内嵌的位图
+const svgContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+// downloadFilename 返回响应中使用的文件名，Telegram 将 GIF 转为 MP4 时同步修改扩展名
+func downloadFilename(filename, key, contentType, actualContentType string) string {
+	if filename == "" {
+		filename = key
+	}
+	if actualContentType != contentType {
+		if ext, ok := global.AllowedMimeTypes[actualContentType]; ok {
+			filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+		}
+	}
+	return filename
+}
+
+// serveDeletedPlaceholder 返回已删除或已过期图片的占位图片
+func serveDeletedPlaceholder(w http.ResponseWriter, r *http.Request, status string) {
+	// 尝试读取占位图片
+	deletedImage, err := os.ReadFile("static/deleted.jpg")
+	if err != nil {
+		// 降级处理：占位图片不存在时返回错误
+		log.Printf("Failed to read deleted placeholder image: %v", err)
+		http.Error(w, "Image has been deleted", http.StatusGone)
+		return
+	}
+
+	// 设置响应头
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(deletedImage)))
+	w.Header().Set("Cache-Control", "public, max-age=86400") // 缓存1天
+	w.Header().Set("X-Image-Status", status)                 // 标识图片状态
+
+	// 返回占位图片
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, werr := w.Write(deletedImage); werr != nil {
+		log.Printf("failed to write deleted placeholder image: %v", werr)
+	}
+}
+
+// cacheScope 私有或有密码的图片不允许共享缓存
+func cacheScope(restricted bool) string {
+	if restricted {
+		return "private"
+	}
+	return "public"
+}
+
 // 登录页面使用 templates/login.html
 func HandleLoginPage(w http.ResponseWriter, r *http.Request) {
 	session, err := global.Store.Get(r, "admin-session")
@@ -637,7 +825,8 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 
 	// 获取分页数据
 	rows, err := global.DB.Query(`
-        SELECT id, proxy_url, ip_address, upload_time, filename, is_active, view_count, content_type
+        SELECT id, proxy_url, ip_address, upload_time, filename, is_active, view_count, content_type, is_private, hotlink_policy,
+            password_hash IS NOT NULL, thumb_file_id IS NOT NULL, duration
         FROM images 
         ORDER BY upload_time DESC
         LIMIT ? OFFSET ?
@@ -656,10 +845,15 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var img ImageRecord
 		err := rows.Scan(&img.ID, &img.ProxyURL, &img.IPAddress, &img.UploadTime,
-			&img.Filename, &img.IsActive, &img.ViewCount, &img.ContentType)
+			&img.Filename, &img.IsActive, &img.ViewCount, &img.ContentType, &img.IsPrivate, &img.Hotlink, &img.HasPassword,
+			&img.HasPoster, &img.Duration)
 		if err != nil {
 			continue
 		}
+		if img.IsPrivate {
+			// 私有图片需要签名才能预览
+			img.ProxyURL = utils.SignPath(img.ProxyURL, time.Hour)
+		}
 		images = append(images, img)
 	}
 
@@ -679,6 +873,7 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		TotalPages int
 		HasPrev    bool
 		HasNext    bool
+		APIKeys    []string // 下载上传工具配置时可选的 API Key，已遮蔽
 	}{
 		Title:      utils.GetPageTitle("管理"),
 		Favicon:    global.AppConfig.Site.Favicon,
@@ -687,6 +882,7 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		TotalPages: totalPages,
 		HasPrev:    page > 1,
 		HasNext:    page < totalPages,
+		APIKeys:    uploaderKeyLabels(),
 	}
 	err = t.Execute(w, data)
 	if err != nil {
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/utils"
+)
+
+// 单张图片的防盗链策略
+const (
+	hotlinkInherit = ""        // 跟随全局配置
+	hotlinkAllow   = "allow"   // 始终允许外站引用
+	hotlinkProtect = "protect" // 始终启用防盗链
+)
+
+// hotlinkProtected 判断图片是否需要进行防盗链检查
+func hotlinkProtected(policy string) bool {
+	switch policy {
+	case hotlinkAllow:
+		return false
+	case hotlinkProtect:
+		return true
+	default:
+		return global.AppConfig.Security.Hotlink.Enabled
+	}
+}
+
+// hotlinkAllowed 根据 Referer/Origin 判断请求来源是否在 allowedHosts 中
+// 本站页面始终允许；没有来源信息的请求由 allowEmptyReferer 决定
+func hotlinkAllowed(r *http.Request) bool {
+	source := r.Header.Get("Referer")
+	if source == "" {
+		source = r.Header.Get("Origin")
+	}
+	if source == "" {
+		return global.AppConfig.Security.Hotlink.AllowEmptyReferer
+	}
+
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	ownHost := r.Host
+	if h, _, err := net.SplitHostPort(ownHost); err == nil {
+		ownHost = h
+	}
+	if strings.EqualFold(ownHost, host) {
+		return true
+	}
+	return utils.MatchHost(host, global.AppConfig.Security.AllowedHosts)
+}
+
+// serveHotlinkBlocked 对盗链请求返回占位图片，未配置占位图片时返回 403
+func serveHotlinkBlocked(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("Expires")
+	w.Header().Set("X-Image-Status", "hotlink-blocked")
+
+	placeholder := global.AppConfig.Security.Hotlink.Placeholder
+	if placeholder == "" {
+		http.Error(w, "Hotlinking is not allowed", http.StatusForbidden)
+		return
+	}
+
+	data, err := os.ReadFile(placeholder)
+	if err != nil {
+		log.Printf("Failed to read hotlink placeholder %s: %v", placeholder, err)
+		http.Error(w, "Hotlinking is not allowed", http.StatusForbidden)
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(placeholder))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, werr := w.Write(data); werr != nil {
+		log.Printf("failed to write hotlink placeholder: %v", werr)
+	}
+}
+
+// HandleSetHotlinkPolicy 设置单张图片的防盗链策略
+func HandleSetHotlinkPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := r.FormValue("policy")
+	switch policy {
+	case hotlinkInherit, hotlinkAllow, hotlinkProtect:
+	default:
+		http.Error(w, "Invalid hotlink policy", http.StatusBadRequest)
+		return
+	}
+
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx,
+			"UPDATE images SET hotlink_policy = ? WHERE id = ?", policy, mux.Vars(r)["id"])
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
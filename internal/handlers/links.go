@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+)
+
+// uploadLink 上传结果中一种可以直接复制使用的链接格式
+type uploadLink struct {
+	Name  string // 响应中 links 的键名
+	Label string // 结果页面显示的标题
+	Text  string
+}
+
+// linkFormat 根据公开链接、文件名和类型生成一种嵌入代码
+type linkFormat struct {
+	Label string
+	Build func(url, filename, contentType string) string
+}
+
+// linkFormats 支持的链接格式，键名与 site.linkFormats 中的名称一致
+var linkFormats = map[string]linkFormat{
+	"url": {
+		Label: "URL 地址",
+		Build: func(url, _, _ string) string { return url },
+	},
+	"markdown": {
+		Label: "Markdown 格式",
+		Build: func(url, filename, contentType string) string {
+			alt := markdownEscaper.Replace(filename)
+			if isImageType(contentType) {
+				return fmt.Sprintf("![%s](%s)", alt, url)
+			}
+			return fmt.Sprintf("[%s](%s)", alt, url)
+		},
+	},
+	"html": {
+		Label: "HTML 格式",
+		Build: func(url, filename, contentType string) string {
+			url, filename = html.EscapeString(url), html.EscapeString(filename)
+			switch {
+			case isImageType(contentType):
+				return fmt.Sprintf(`<img src="%s" alt="%s">`, url, filename)
+			case imaging.IsVideo(contentType):
+				return fmt.Sprintf(`<video src="%s" controls></video>`, url)
+			default:
+				return fmt.Sprintf(`<a href="%s">%s</a>`, url, filename)
+			}
+		},
+	},
+	"bbcode": {
+		Label: "BBCode 格式",
+		Build: func(url, filename, contentType string) string {
+			if isImageType(contentType) {
+				return fmt.Sprintf("[img]%s[/img]", url)
+			}
+			return fmt.Sprintf("[url=%s]%s[/url]", url, bbcodeEscaper.Replace(filename))
+		},
+	},
+	// 图片外层再套一个指向自身的链接，帖子中的图片可以点击在新页面打开
+	"bbcodeClickable": {
+		Label: "BBCode 格式（图片带链接）",
+		Build: func(url, filename, contentType string) string {
+			if isImageType(contentType) {
+				return fmt.Sprintf("[url=%s][img]%s[/img][/url]", url, url)
+			}
+			return fmt.Sprintf("[url=%s]%s[/url]", url, bbcodeEscaper.Replace(filename))
+		},
+	},
+}
+
+// defaultLinkFormats 未配置 site.linkFormats 时提供的格式和显示顺序
+var defaultLinkFormats = []string{"url", "markdown", "html", "bbcode", "bbcodeClickable"}
+
+// markdownEscaper 转义 Markdown 链接文字中的方括号
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// bbcodeEscaper 去掉链接文字中的方括号，BBCode 没有转义写法，文件名中的方括号会被解析为标签
+var bbcodeEscaper = strings.NewReplacer("[", "", "]", "")
+
+// isImageType 文件能否作为图片嵌入
+func isImageType(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// buildLinks 按配置的顺序生成各种格式的链接，忽略不认识的格式名称
+func buildLinks(url, filename, contentType string) []uploadLink {
+	names := global.AppConfig.Site.LinkFormats
+	if len(names) == 0 {
+		names = defaultLinkFormats
+	}
+	links := make([]uploadLink, 0, len(names))
+	for _, name := range names {
+		format, ok := linkFormats[name]
+		if !ok {
+			continue
+		}
+		links = append(links, uploadLink{Name: name, Label: format.Label, Text: format.Build(url, filename, contentType)})
+	}
+	return links
+}
+
+// linksMap 转换为 API 响应中以格式名称为键的 links 字段
+func linksMap(links []uploadLink) map[string]string {
+	if len(links) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(links))
+	for _, link := range links {
+		m[link.Name] = link.Text
+	}
+	return m
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/utils"
+)
+
+// defaultShortCodeLength 短链接默认长度
+const defaultShortCodeLength = 6
+
+// maxImageLifetime 上传时可设置的最长有效期
+const maxImageLifetime = 365 * 24 * time.Hour
+
+// maxPasswordLength 访问密码的最大长度
+const maxPasswordLength = 128
+
+// slugPattern 自定义链接名只允许字母、数字和连字符，避免与 LIKE 通配符冲突
+var slugPattern = regexp.MustCompile(`^[A-Za-z0-9-]{3,64}$`)
+
+// lookupKeyPattern 访问链接中的链接名或短链接，短链接长度可以配置，因此不限制最短长度
+var lookupKeyPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// extPattern 访问链接中的扩展名
+var extPattern = regexp.MustCompile(`^\.[A-Za-z0-9]{1,10}$`)
+
+// errSlugTaken 自定义链接名已被占用
+var errSlugTaken = errors.New("slug already taken")
+
+// uploadOptions 上传时由用户指定的可选参数
+type uploadOptions struct {
+	Slug      string        // 自定义链接名，访问地址为 /file/{slug}
+	ShortCode bool          // 是否生成短链接，访问地址为 /i/{code}
+	Private   bool          // 私有图片只能通过签名链接访问
+	ExpiresIn time.Duration // 有效期，0 表示永久有效
+	MaxViews  int           // 最大访问次数，0 表示不限制
+	Password  string        // 访问密码，为空表示无需密码
+	// PasswordHash 已经计算好的访问密码哈希，断点续传上传只保存哈希，不保存明文密码
+	PasswordHash string
+}
+
+// needsOwnRecord 是否需要为本次上传创建独立的记录，而不是直接返回已有的相同图片
+func (o uploadOptions) needsOwnRecord() bool {
+	return o.Slug != "" || o.ShortCode || o.Private || o.ExpiresIn > 0 || o.MaxViews > 0 || o.protected()
+}
+
+// protected 是否设置了访问密码
+func (o uploadOptions) protected() bool {
+	return o.Password != "" || o.PasswordHash != ""
+}
+
+// passwordHash 返回存入数据库的密码哈希，未设置密码时为 NULL
+func (o uploadOptions) passwordHash() (sql.NullString, error) {
+	if o.PasswordHash != "" {
+		return sql.NullString{String: o.PasswordHash, Valid: true}, nil
+	}
+	if o.Password == "" {
+		return sql.NullString{}, nil
+	}
+	hash, err := utils.HashPassword(o.Password)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: hash, Valid: true}, nil
+}
+
+// expiresAt 返回存入数据库的过期时间，格式与 SQLite 的 datetime('now') 一致以便直接比较
+func (o uploadOptions) expiresAt() sql.NullString {
+	if o.ExpiresIn <= 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{
+		String: time.Now().Add(o.ExpiresIn).UTC().Format(time.DateTime),
+		Valid:  true,
+	}
+}
+
+// parseUploadOptions 解析上传参数，formValue 通常为 r.FormValue，也可以是 JSON 请求体中的字段
+func parseUploadOptions(formValue func(key string) string) (uploadOptions, error) {
+	var opts uploadOptions
+
+	if slug := strings.TrimSpace(formValue("slug")); slug != "" {
+		if !slugPattern.MatchString(slug) {
+			return opts, errors.New("自定义链接名只能包含字母、数字和连字符，长度为3-64个字符")
+		}
+		// UUID 形式的名称保留给系统生成的链接
+		if _, err := uuid.Parse(slug); err == nil {
+			return opts, errors.New("自定义链接名不能是UUID格式")
+		}
+		opts.Slug = slug
+	}
+	opts.ShortCode = isTruthy(formValue("short"))
+	opts.Private = isTruthy(formValue("private"))
+
+	if expiresIn := strings.TrimSpace(formValue("expiresIn")); expiresIn != "" {
+		d, err := utils.ParseFlexibleDuration(expiresIn)
+		if err != nil || d <= 0 || d > maxImageLifetime {
+			return opts, errors.New("有效期格式无效，支持如 1h、7d 或秒数，最长365天")
+		}
+		opts.ExpiresIn = d
+	}
+
+	if maxViews := strings.TrimSpace(formValue("maxViews")); maxViews != "" {
+		n, err := strconv.Atoi(maxViews)
+		if err != nil || n < 0 {
+			return opts, errors.New("最大访问次数必须是非负整数")
+		}
+		opts.MaxViews = n
+	}
+
+	if password := formValue("password"); password != "" {
+		if len(password) > maxPasswordLength {
+			return opts, fmt.Errorf("访问密码不能超过%d个字符", maxPasswordLength)
+		}
+		opts.Password = password
+	}
+
+	return opts, nil
+}
+
+// isTruthy 解析表单中的布尔值
+func isTruthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+// reserveSlug 确定本次上传使用的链接名：校验自定义链接名是否可用，或生成不冲突的短链接
+// 返回空字符串表示使用默认的 UUID 链接
+func reserveSlug(opts uploadOptions) (string, error) {
+	if opts.Slug != "" {
+		taken, err := slugExists(opts.Slug)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", errSlugTaken
+		}
+		return opts.Slug, nil
+	}
+
+	if !opts.ShortCode {
+		return "", nil
+	}
+
+	length := global.AppConfig.Upload.ShortCodeLength
+	if length <= 0 {
+		length = defaultShortCodeLength
+	}
+	for range 5 {
+		code, err := utils.RandomBase62(length)
+		if err != nil {
+			return "", err
+		}
+		taken, err := slugExists(code)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique short code of length %d", length)
+}
+
+// isSlugConflict 插入记录时是否违反了链接名的唯一索引
+func isSlugConflict(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), "images.slug")
+}
+
+// slugExists 检查链接名是否已被使用
+func slugExists(slug string) (bool, error) {
+	var count int
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM images WHERE slug = ?", slug,
+		).Scan(&count)
+	})
+	return count > 0, err
+}
+
+// publicPath 返回本次上传对外展示的访问路径，私有图片返回带签名的链接
+func publicPath(proxyURL, slug, fileExt string, opts uploadOptions) string {
+	path := proxyURL
+	switch {
+	case slug == "":
+	case opts.Slug != "":
+		path = "/file/" + slug + fileExt
+	default:
+		path = "/i/" + slug
+	}
+
+	if opts.Private {
+		return utils.SignPath(path, utils.SignedURLTTL())
+	}
+	return path
+}
+
+// imageLookupClause 根据访问链接中的标识生成 WHERE 之后的查询子句
+// 标识可以是自定义链接名、短链接或 UUID（可带扩展名），优先匹配链接名；标识格式不正确时 ok 为 false
+func imageLookupClause(key string) (clause string, args []any, ok bool) {
+	ext := path.Ext(key)
+	name := strings.TrimSuffix(key, ext)
+	if ext != "" && !extPattern.MatchString(ext) {
+		return "", nil, false
+	}
+	isUUID := len(name) == 36 && uuid.Validate(name) == nil
+	if !isUUID && !lookupKeyPattern.MatchString(name) {
+		return "", nil, false
+	}
+
+	proxyURL := "/file/" + key
+	if isUUID && ext == "" {
+		// 不带扩展名的 UUID 链接，UUID 已经校验过，不会包含 LIKE 通配符
+		return "(slug = ? OR proxy_url = ? OR proxy_url LIKE ?) ORDER BY slug = ? DESC LIMIT 1",
+			[]any{name, proxyURL, proxyURL + ".%", name}, true
+	}
+	return "(slug = ? OR proxy_url = ?) ORDER BY slug = ? DESC LIMIT 1",
+		[]any{name, proxyURL, name}, true
+}
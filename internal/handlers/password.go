@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/template"
+	"hosting/internal/utils"
+)
+
+const (
+	maxUnlockFailures = 5                // 单张图片允许连续输错密码的次数
+	unlockLockout     = 15 * time.Minute // 输错次数过多后的锁定时间
+	unlockTokenTTL    = 24 * time.Hour   // 输入密码后访问令牌的有效期
+)
+
+// unlockAttempts 单张图片的密码尝试记录
+type unlockAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var (
+	unlockMu       sync.Mutex
+	unlockFailures = make(map[int]*unlockAttempts)
+)
+
+// unlockLockedFor 返回图片剩余的锁定时间，未锁定时返回 0
+func unlockLockedFor(imageID int) time.Duration {
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
+
+	if a, ok := unlockFailures[imageID]; ok {
+		return max(time.Until(a.lockedUntil), 0)
+	}
+	return 0
+}
+
+// recordUnlockFailure 记录一次密码错误，连续错误达到上限后锁定该图片
+func recordUnlockFailure(imageID int) {
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
+
+	now := time.Now()
+	a, ok := unlockFailures[imageID]
+	if !ok || now.Sub(a.lastFailure) > unlockLockout {
+		a = &unlockAttempts{}
+		unlockFailures[imageID] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= maxUnlockFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(unlockLockout)
+		log.Printf("Image %d locked for %v after too many wrong passwords", imageID, unlockLockout)
+	}
+
+	// 顺带清理已经过期的记录，避免占用内存
+	if len(unlockFailures) > 1000 {
+		for id, entry := range unlockFailures {
+			if now.Sub(entry.lastFailure) > unlockLockout && now.After(entry.lockedUntil) {
+				delete(unlockFailures, id)
+			}
+		}
+	}
+}
+
+// resetUnlockFailures 密码正确后清除错误记录
+func resetUnlockFailures(imageID int) {
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
+	delete(unlockFailures, imageID)
+}
+
+// unlockSubject 访问令牌绑定的内容，包含密码哈希以便修改密码后令牌失效
+func unlockSubject(imageID int, passwordHash string) string {
+	return fmt.Sprintf("%d\n%s", imageID, passwordHash)
+}
+
+// unlockCookieName 保存访问令牌的 Cookie 名称
+func unlockCookieName(imageID int) string {
+	return "unlock_" + strconv.Itoa(imageID)
+}
+
+// isAdminRequest 判断请求是否来自已登录的管理员
+func isAdminRequest(r *http.Request) bool {
+	if global.Store == nil {
+		return false
+	}
+	session, err := global.Store.Get(r, "admin-session")
+	if err != nil {
+		return false
+	}
+	auth, ok := session.Values["authenticated"].(bool)
+	return ok && auth
+}
+
+// authorizeImagePassword 检查访问有密码图片的请求，未通过时写入响应并返回 false
+// 依次接受管理员登录状态、?token= 或 Cookie 中的访问令牌、X-Image-Password 请求头
+func authorizeImagePassword(w http.ResponseWriter, r *http.Request, imageID int, passwordHash string) bool {
+	if isAdminRequest(r) {
+		return true
+	}
+
+	subject := unlockSubject(imageID, passwordHash)
+	if token := r.URL.Query().Get("token"); token != "" && utils.VerifyUnlockToken(subject, token) {
+		return true
+	}
+	if cookie, err := r.Cookie(unlockCookieName(imageID)); err == nil && utils.VerifyUnlockToken(subject, cookie.Value) {
+		return true
+	}
+
+	if password := r.Header.Get("X-Image-Password"); password != "" {
+		if wait := unlockLockedFor(imageID); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many wrong passwords, try again later", http.StatusTooManyRequests)
+			return false
+		}
+		if utils.CheckPassword(password, passwordHash) {
+			resetUnlockFailures(imageID)
+			return true
+		}
+		recordUnlockFailure(imageID)
+		http.Error(w, "Wrong password", http.StatusUnauthorized)
+		return false
+	}
+
+	serveUnlockPage(w, r, "", http.StatusUnauthorized)
+	return false
+}
+
+// serveUnlockPage 显示输入访问密码的页面
+func serveUnlockPage(w http.ResponseWriter, r *http.Request, message string, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("Expires")
+
+	t, ok := template.GetTemplate("unlock")
+	if !ok {
+		http.Error(w, "Password required", status)
+		return
+	}
+
+	data := struct {
+		Title   string
+		Favicon string
+		Action  string
+		Error   string
+	}{
+		Title:   utils.GetPageTitle("输入访问密码"),
+		Favicon: global.AppConfig.Site.Favicon,
+		Action:  r.URL.RequestURI(),
+		Error:   message,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Failed to render unlock page: %v", err)
+	}
+}
+
+// HandleUnlockImage 校验图片访问密码，成功后写入访问令牌 Cookie 并跳转回图片地址
+// 请求头 Accept 为 application/json 时直接返回令牌，可用于 ?token= 参数
+func HandleUnlockImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["uuid"]
+	if key == "" {
+		key = vars["code"]
+	}
+	wantsJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+
+	var imageID int
+	var passwordHash sql.NullString
+	clause, args, ok := imageLookupClause(key)
+	if !ok {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx,
+			"SELECT id, password_hash FROM images WHERE is_active = 1 AND "+clause, args...,
+		).Scan(&imageID, &passwordHash)
+	})
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !passwordHash.Valid) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Failed to query image %s for unlock: %v", key, err)
+		return
+	}
+
+	fail := func(message string, status int) {
+		if wantsJSON {
+			w.Header().Set("Content-Type", "application/json")
+			sendJSONError(w, message, status)
+			return
+		}
+		serveUnlockPage(w, r, message, status)
+	}
+
+	if wait := unlockLockedFor(imageID); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		fail(fmt.Sprintf("密码错误次数过多，请%d分钟后再试", int(math.Ceil(wait.Minutes()))), http.StatusTooManyRequests)
+		return
+	}
+
+	if !utils.CheckPassword(r.FormValue("password"), passwordHash.String) {
+		recordUnlockFailure(imageID)
+		fail("密码错误", http.StatusUnauthorized)
+		return
+	}
+	resetUnlockFailures(imageID)
+
+	token := utils.UnlockToken(unlockSubject(imageID, passwordHash.String), unlockTokenTTL)
+	if wantsJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(APIResponse{
+			Success: true,
+			Message: "密码正确",
+			Data: map[string]string{
+				"token":     token,
+				"expiresAt": time.Now().Add(unlockTokenTTL).UTC().Format(time.RFC3339),
+			},
+		}); err != nil {
+			log.Printf("Failed to encode unlock response: %v", err)
+		}
+		return
+	}
+
+	// 同一张图片可以通过 /file/、/i/ 和 /download/ 等多个路径访问，Cookie 名称已经区分了图片
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(imageID),
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(unlockTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   !global.IsDevelopment,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"hosting/internal/logger"
+	"hosting/internal/quota"
+	"hosting/internal/utils"
+)
+
+// apiKeyID 写入图片记录的 API Key 标识，匿名上传为 NULL
+func apiKeyID(key string) sql.NullString {
+	if key == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: quota.KeyID(key), Valid: true}
+}
+
+// checkUploadQuota 按文件的实际大小检查并预占 API Key 的配额，上传失败时在 uploadItem.close 中归还
+func checkUploadQuota(_ context.Context, item *uploadItem) *uploadError {
+	key := item.Request.APIKey
+	if key == "" {
+		return nil
+	}
+	limits := quota.For(key)
+	if !limits.Enabled() {
+		return nil
+	}
+	reservation, err := quota.Reserve(quota.KeyID(key), limits, item.Spool.size)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		logger.Info("[%s] API Key %s 超过配额: %s", item.Request.RequestID, utils.MaskAPIKey(key), exceeded.Message)
+		return &uploadError{Message: exceeded.Message, Code: http.StatusTooManyRequests}
+	}
+	if err != nil {
+		logger.Warn("[%s] 读取配额用量失败: %v", item.Request.RequestID, err)
+		return nil
+	}
+	item.quota = reservation
+	return nil
+}
+
+// recordUploadQuota 记录 API Key 今天的上传次数和大小，未设置配额的 API Key 同样记录
+func recordUploadQuota(_ context.Context, item *uploadItem, result *uploadResult) {
+	key := item.Request.APIKey
+	if key == "" {
+		return
+	}
+	if item.quota != nil {
+		if err := item.quota.Commit(result.Size); err != nil {
+			logger.Error("[%s] 记录配额用量失败: %v", item.Request.RequestID, err)
+		}
+		return
+	}
+	if err := quota.Record(quota.KeyID(key), result.Size); err != nil {
+		logger.Error("[%s] 记录配额用量失败: %v", item.Request.RequestID, err)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/utils"
+)
+
+// maxSignedURLTTL 签名链接允许的最长有效期
+const maxSignedURLTTL = 365 * 24 * time.Hour
+
+// SignRequest 生成签名链接的API请求
+type SignRequest struct {
+	URL       string `json:"url"`       // 图片链接，可以是完整URL或 /file/...、/i/... 路径
+	ExpiresIn string `json:"expiresIn"` // 有效期，如 "1h"、"7d" 或秒数，留空使用默认值
+}
+
+// parseTTL 解析签名链接有效期，留空时使用默认值
+func parseTTL(value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return utils.SignedURLTTL(), nil
+	}
+
+	ttl, err := utils.ParseFlexibleDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("无效的有效期: %s", value)
+	}
+	if ttl <= 0 || ttl > maxSignedURLTTL {
+		return 0, errors.New("有效期必须大于0且不超过365天")
+	}
+	return ttl, nil
+}
+
+// HandleAPISign 为图片生成带过期时间的签名链接
+func HandleAPISign(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req SignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		sendJSONError(w, "无法解析请求数据", http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseTTL(req.ExpiresIn)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (!strings.HasPrefix(parsed.Path, "/file/") && !strings.HasPrefix(parsed.Path, "/i/")) {
+		sendJSONError(w, "无效的图片链接", http.StatusBadRequest)
+		return
+	}
+
+	// 确认图片存在
+	key := parsed.Path[strings.LastIndex(parsed.Path, "/")+1:]
+	clause, args, ok := imageLookupClause(key)
+	if !ok {
+		sendJSONError(w, "图片不存在", http.StatusNotFound)
+		return
+	}
+	var id int
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx, "SELECT id FROM images WHERE "+clause, args...).Scan(&id)
+	})
+	if err != nil {
+		sendJSONError(w, "图片不存在", http.StatusNotFound)
+		return
+	}
+
+	writeSignedURL(w, r, parsed.Path, ttl)
+}
+
+// HandleAdminSign 管理后台为指定图片生成签名链接
+func HandleAdminSign(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ttl, err := parseTTL(r.FormValue("ttl"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var proxyURL string
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx,
+			"SELECT proxy_url FROM images WHERE id = ?", mux.Vars(r)["id"],
+		).Scan(&proxyURL)
+	})
+	if err != nil {
+		sendJSONError(w, "图片不存在", http.StatusNotFound)
+		return
+	}
+
+	writeSignedURL(w, r, proxyURL, ttl)
+}
+
+// HandleTogglePrivate 切换图片的私有状态
+func HandleTogglePrivate(w http.ResponseWriter, r *http.Request) {
+	_, err := global.DB.Exec("UPDATE images SET is_private = NOT is_private WHERE id = ?", mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeSignedURL 返回签名链接及其过期时间
+func writeSignedURL(w http.ResponseWriter, r *http.Request, path string, ttl time.Duration) {
+	signed := utils.SignPath(path, ttl)
+	response := APIResponse{
+		Success: true,
+		Data: map[string]string{
+			"url":       fmt.Sprintf("%s://%s%s", requestScheme(r), r.Host, signed),
+			"expiresAt": time.Now().Add(ttl).Format(time.RFC3339),
+		},
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("failed to write signed URL response: %v", err)
+	}
+}
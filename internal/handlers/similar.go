@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/imaging"
+	"hosting/internal/template"
+	"hosting/internal/utils"
+)
+
+// defaultSimilarThreshold 默认的相似图片汉明距离阈值
+const defaultSimilarThreshold = 10
+
+// 相似图片页面的处理上限
+const (
+	maxSimilarImages     = 20000 // 最多参与比较的图片数，超过时只比较最近上传的图片
+	similarGroupsPerPage = 20    // 每页显示的分组数
+)
+
+// SimilarGroup 一组视觉上相似的图片
+type SimilarGroup struct {
+	Images []ImageRecord
+}
+
+// HandleAdminSimilar 按感知哈希将相似图片分组展示，便于发现重新编码后的重复内容
+// 只比较仍然有效的图片，分组结果分页显示
+func HandleAdminSimilar(w http.ResponseWriter, r *http.Request) {
+	threshold := global.AppConfig.Admin.SimilarThreshold
+	if threshold <= 0 {
+		threshold = defaultSimilarThreshold
+	}
+	if t := r.URL.Query().Get("threshold"); t != "" {
+		if parsed, err := strconv.Atoi(t); err == nil && parsed >= 0 && parsed <= 64 {
+			threshold = parsed
+		}
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var images []ImageRecord
+	var hashes []uint64
+	err = db.WithDBTimeout(func(ctx context.Context) error {
+		images, hashes = nil, nil
+		rows, err := global.DB.QueryContext(ctx, `
+			SELECT id, proxy_url, ip_address, upload_time, filename, is_active, view_count, content_type, is_private, phash
+			FROM images
+			WHERE is_active = 1 AND phash IS NOT NULL AND phash != ''
+			ORDER BY upload_time DESC
+			LIMIT ?`, maxSimilarImages+1)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := rows.Close(); cerr != nil {
+				log.Printf("failed to close rows: %v", cerr)
+			}
+		}()
+
+		for rows.Next() {
+			var img ImageRecord
+			var phash string
+			err := rows.Scan(&img.ID, &img.ProxyURL, &img.IPAddress, &img.UploadTime,
+				&img.Filename, &img.IsActive, &img.ViewCount, &img.ContentType, &img.IsPrivate, &phash)
+			if err != nil {
+				continue
+			}
+			hash, err := imaging.ParseHash(phash)
+			if err != nil {
+				continue
+			}
+			if img.IsPrivate {
+				// 私有图片需要签名才能预览
+				img.ProxyURL = utils.SignPath(img.ProxyURL, time.Hour)
+			}
+			images = append(images, img)
+			hashes = append(hashes, hash)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	truncated := len(images) > maxSimilarImages
+	if truncated {
+		images, hashes = images[:maxSimilarImages], hashes[:maxSimilarImages]
+	}
+	// 按上传时间从早到晚排列，分组中最早上传的图片在前
+	slices.Reverse(images)
+	slices.Reverse(hashes)
+
+	groups := groupSimilar(images, hashes, threshold)
+	totalPages := max((len(groups)+similarGroupsPerPage-1)/similarGroupsPerPage, 1)
+	page = min(page, totalPages)
+	offset := (page - 1) * similarGroupsPerPage
+	pageGroups := groups[offset:min(offset+similarGroupsPerPage, len(groups))]
+
+	t, ok := template.GetTemplate("similar")
+	if !ok {
+		http.Error(w, "Template not found", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Title       string
+		Favicon     string
+		Groups      []SimilarGroup
+		Threshold   int
+		Offset      int
+		Page        int
+		TotalPages  int
+		HasPrev     bool
+		HasNext     bool
+		Truncated   bool
+		MaxImages   int
+		TotalGroups int
+	}{
+		Title:       utils.GetPageTitle("相似图片"),
+		Favicon:     global.AppConfig.Site.Favicon,
+		Groups:      pageGroups,
+		Threshold:   threshold,
+		Offset:      offset,
+		Page:        page,
+		TotalPages:  totalPages,
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		Truncated:   truncated,
+		MaxImages:   maxSimilarImages,
+		TotalGroups: len(groups),
+	}
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// groupSimilar 使用并查集将汉明距离不超过阈值的图片合并为一组，只返回包含多张图片的分组
+// 通过 BK 树查找每张图片的近邻，避免两两比较
+func groupSimilar(images []ImageRecord, hashes []uint64, threshold int) []SimilarGroup {
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var tree bkTree
+	for i, hash := range hashes {
+		tree.insert(hash, i)
+	}
+	for i, hash := range hashes {
+		tree.search(hash, threshold, func(j int) {
+			if j != i {
+				parent[find(j)] = find(i)
+			}
+		})
+	}
+
+	members := make(map[int][]ImageRecord)
+	var order []int
+	for i, img := range images {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], img)
+	}
+
+	var groups []SimilarGroup
+	for _, root := range order {
+		if len(members[root]) > 1 {
+			groups = append(groups, SimilarGroup{Images: members[root]})
+		}
+	}
+
+	// 图片数量多的分组排在前面
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Images) > len(groups[j].Images)
+	})
+	return groups
+}
+
+// bkTree 以汉明距离为度量的 BK 树，用于查找距离不超过阈值的哈希
+type bkTree struct {
+	root *bkNode
+}
+
+// bkNode 树中的一个哈希，相同哈希的图片共用一个节点
+type bkNode struct {
+	hash     uint64
+	indices  []int
+	children map[int]*bkNode // 按与本节点的距离索引
+}
+
+func (t *bkTree) insert(hash uint64, index int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, indices: []int{index}}
+		return
+	}
+	node := t.root
+	for {
+		d := imaging.HammingDistance(node.hash, hash)
+		if d == 0 {
+			node.indices = append(node.indices, index)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: hash, indices: []int{index}}
+			return
+		}
+		node = child
+	}
+}
+
+// search 对距离不超过 threshold 的每张图片调用 fn
+func (t *bkTree) search(hash uint64, threshold int, fn func(index int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := imaging.HammingDistance(node.hash, hash)
+		if d <= threshold {
+			for _, index := range node.indices {
+				fn(index)
+			}
+		}
+		// 三角不等式：只有距离在 [d-threshold, d+threshold] 内的子树可能包含结果
+		for dist, child := range node.children {
+			if dist >= d-threshold && dist <= d+threshold {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/remote"
+)
+
+// maxOptionsBodySize 不包含文件内容的请求体（如链接导入）的最大长度
+const maxOptionsBodySize = 64 * 1024
+
+// 远程抓取的默认限制
+const (
+	defaultRemoteTimeout      = 15 * time.Second
+	defaultRemoteMaxRedirects = 3
+	remoteUserAgent           = "goImage-fetcher/1.0"
+)
+
+// errTooManyFiles 表单中的文件数超过限制
+var errTooManyFiles = errors.New("too many files")
+
+// uploadSource 待保存的上传文件，来自表单文件、远程链接或请求体，内容已经保存在本地
+type uploadSource struct {
+	Spool    *spooledFile
+	Filename string     // 客户端提供的原始文件名，未经过清理
+	Options  url.Values // 随文件一起提交的上传参数，为 nil 时从请求表单中读取
+	// PasswordHash 已经计算好的访问密码哈希，优先于 Options 中的 password
+	PasswordHash string
+}
+
+// Close 关闭并删除本地副本
+func (s *uploadSource) Close() {
+	s.Spool.Close()
+}
+
+// formFile 流式读取表单时得到的一个文件，Err 不为空时表示该文件没有保存下来
+type formFile struct {
+	Filename string
+	Spool    *spooledFile
+	Err      error
+}
+
+// streamedForm 流式读取的 multipart 表单，文件直接写入各自的本地副本，其他字段作为上传参数
+type streamedForm struct {
+	Files   []formFile
+	Options url.Values
+}
+
+// source 返回第 i 个文件对应的上传来源，上传参数由表单中的所有文件共用
+func (f *streamedForm) source(i int) *uploadSource {
+	return &uploadSource{Spool: f.Files[i].Spool, Filename: f.Files[i].Filename, Options: f.Options}
+}
+
+// Close 删除所有文件的本地副本
+func (f *streamedForm) Close() {
+	for _, file := range f.Files {
+		if file.Spool != nil {
+			file.Spool.Close()
+		}
+	}
+}
+
+// readMultipartForm 按顺序读取 multipart 表单，不经过 ParseMultipartForm 的内存和临时文件缓冲
+// fields 中字段的文件边接收边写入本地副本，其他字段的文件被忽略；单个文件超过 maxSize 时只记录该文件的错误
+// 文件数超过 maxFiles 时返回 errTooManyFiles
+func readMultipartForm(r *http.Request, fields []string, maxFiles int, maxSize int64) (*streamedForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &streamedForm{Options: url.Values{}}
+	optionsSize := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" {
+			// 普通字段是上传参数，总长度有限制
+			value, err := io.ReadAll(io.LimitReader(part, int64(maxOptionsBodySize-optionsSize+1)))
+			optionsSize += len(value)
+			if err == nil && optionsSize > maxOptionsBodySize {
+				err = errors.New("form fields too large")
+			}
+			if err != nil {
+				form.Close()
+				return nil, err
+			}
+			form.Options.Add(name, string(value))
+			continue
+		}
+		if !slices.Contains(fields, name) {
+			continue
+		}
+		if len(form.Files) >= maxFiles {
+			form.Close()
+			return nil, errTooManyFiles
+		}
+
+		spool, err := spoolReader(part, maxSize)
+		if err != nil && !errors.Is(err, errFileTooLarge) {
+			form.Close()
+			return nil, err
+		}
+		form.Files = append(form.Files, formFile{Filename: part.FileName(), Spool: spool, Err: err})
+	}
+}
+
+// isTooLarge 判断错误是否由文件或请求体超过大小限制引起
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.Is(err, errFileTooLarge) || errors.As(err, &maxBytesErr)
+}
+
+// remoteEnabled 是否允许从远程链接导入
+func remoteEnabled() bool {
+	return global.AppConfig.Upload.Remote.Enabled
+}
+
+// remoteOptions 根据配置生成远程抓取的限制，超时时间不超过上传请求本身的超时
+func remoteOptions() remote.Options {
+	timeout := defaultRemoteTimeout
+	if d, err := time.ParseDuration(global.AppConfig.Upload.Remote.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	if timeout > global.UploadTimeout {
+		timeout = global.UploadTimeout
+	}
+	maxRedirects := global.AppConfig.Upload.Remote.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultRemoteMaxRedirects
+	}
+	return remote.Options{
+		MaxBytes:     maxUploadSize(),
+		Timeout:      timeout,
+		MaxRedirects: maxRedirects,
+		UserAgent:    remoteUserAgent,
+	}
+}
+
+// acceptRemoteType 根据远程服务器声明的类型提前拒绝网页等明显不是图片的内容
+// 最终类型仍以文件头识别的结果为准
+func acceptRemoteType(contentType string) bool {
+	if global.AppConfig.Upload.Files.Enabled {
+		return true
+	}
+	switch {
+	case contentType == "", contentType == "application/octet-stream", contentType == "binary/octet-stream":
+		return true
+	case strings.HasPrefix(contentType, "image/"), strings.HasPrefix(contentType, "video/"):
+		return true
+	}
+	return false
+}
+
+// remoteFileSource 下载远程链接指向的文件
+func remoteFileSource(ctx context.Context, rawURL string) (*uploadSource, error) {
+	opts := remoteOptions()
+	file, err := remote.Fetch(ctx, rawURL, opts, acceptRemoteType)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Body.Close(); cerr != nil {
+			logger.Error("failed to close remote response body: %v", cerr)
+		}
+	}()
+
+	// 边下载边写入本地副本，不在内存中保留整个文件
+	spool, err := spoolReader(file.Body, opts.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadSource{Spool: spool, Filename: file.Filename}, nil
+}
+
+// remoteErrorResponse 将远程抓取的错误转换为返回给用户的提示和状态码
+func remoteErrorResponse(err error) (string, int) {
+	switch {
+	case errors.Is(err, remote.ErrInvalidURL):
+		return "链接无效，仅支持 http 和 https 链接", http.StatusBadRequest
+	case errors.Is(err, remote.ErrForbiddenAddress):
+		return "不允许从内网或本机地址导入", http.StatusBadRequest
+	case errors.Is(err, remote.ErrTooManyRedirects):
+		return "链接重定向次数过多", http.StatusBadRequest
+	case errors.Is(err, remote.ErrTooLarge):
+		return "远程文件大小超过限制", http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return "下载远程文件超时", http.StatusGatewayTimeout
+	}
+	return "无法下载远程文件", http.StatusBadGateway
+}
+
+// remoteSourceLabel 日志中记录的远程链接，去掉查询参数以免泄露令牌
+func remoteSourceLabel(rawURL string) string {
+	if u, err := remote.ParseURL(rawURL); err == nil {
+		return u.Scheme + "://" + u.Host + u.Path
+	}
+	return "(invalid URL)"
+}
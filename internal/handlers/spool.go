@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// sniffLength 用于识别文件类型的文件头长度，与 http.DetectContentType 一致
+const sniffLength = 512
+
+// errFileTooLarge 文件超过大小限制
+var errFileTooLarge = errors.New("file too large")
+
+// spooledFile 上传文件在服务器上唯一的一份本地副本
+// 接收的同时计算 SHA-256 并保留文件头，之后的类型识别、特征提取和发送到 Telegram 都直接使用这个文件
+type spooledFile struct {
+	file   *os.File
+	size   int64
+	hash   string
+	header []byte
+	owned  bool // Close 时是否删除文件
+	closed bool
+}
+
+// headerWriter 只保留写入内容的前 sniffLength 个字节
+type headerWriter struct {
+	buf []byte
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if remaining := sniffLength - len(w.buf); remaining > 0 {
+		w.buf = append(w.buf, p[:min(remaining, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// spoolReader 将 src 写入临时文件，超过 maxSize 时删除已写入的部分并返回 errFileTooLarge
+func spoolReader(src io.Reader, maxSize int64) (*spooledFile, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &spooledFile{file: file, owned: true}
+
+	hasher := sha256.New()
+	header := &headerWriter{buf: make([]byte, 0, sniffLength)}
+	// 多读一个字节用于判断是否超过限制
+	n, err := io.Copy(io.MultiWriter(file, hasher, header), io.LimitReader(src, maxSize+1))
+	if err == nil && n > maxSize {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	spool.size = n
+	spool.hash = hex.EncodeToString(hasher.Sum(nil))
+	spool.header = header.buf
+	return spool, nil
+}
+
+// spoolExisting 使用已经保存在本地的文件，只读取一遍计算哈希，Close 时不删除文件
+func spoolExisting(file *os.File) (*spooledFile, error) {
+	hasher := sha256.New()
+	header := &headerWriter{buf: make([]byte, 0, sniffLength)}
+	n, err := io.Copy(io.MultiWriter(hasher, header), file)
+	if err != nil {
+		return nil, err
+	}
+	return &spooledFile{
+		file:   file,
+		size:   n,
+		hash:   hex.EncodeToString(hasher.Sum(nil)),
+		header: header.buf,
+	}, nil
+}
+
+// Path 本地文件路径，用于解码图片提取特征
+func (s *spooledFile) Path() string {
+	return s.file.Name()
+}
+
+// Reader 从头读取文件内容，每次调用返回独立的读取位置
+// 返回值不实现 io.Closer，交给 Telegram 客户端发送时不会关闭底层文件
+func (s *spooledFile) Reader() io.Reader {
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+// telegramFile 将本地副本作为 Telegram 上传的文件，文件名带上识别出的扩展名
+func telegramFile(spool *spooledFile, fileExt string) tgbotapi.FileReader {
+	return tgbotapi.FileReader{Name: "upload" + fileExt, Reader: spool.Reader()}
+}
+
+// Close 关闭文件，临时文件同时删除，重复调用时忽略
+func (s *spooledFile) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if err := s.file.Close(); err != nil {
+		log.Printf("failed to close spooled file %s: %v", s.file.Name(), err)
+	}
+	if !s.owned {
+		return
+	}
+	if err := os.Remove(s.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("failed to remove spooled file %s: %v", s.file.Name(), err)
+	}
+}
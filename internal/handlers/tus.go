@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/quota"
+	"hosting/internal/tus"
+	"hosting/internal/utils"
+)
+
+// 断点续传的默认设置
+const (
+	defaultTusExpiration = 24 * time.Hour
+	// tusPatchTimeout 单个 PATCH 请求读取数据的最长时间，超时后已收到的部分仍然保留
+	tusPatchTimeout = 10 * time.Minute
+	// tusOffsetContentType PATCH 请求体必须使用的类型
+	tusOffsetContentType = "application/offset+octet-stream"
+	// tusPasswordHashKey 保存在元数据中的访问密码哈希，创建时替换客户端提交的 password
+	tusPasswordHashKey = "password_hash"
+	// tusOwnerKey 保存在元数据中的创建者 API Key 标识，后续请求必须使用同一个 API Key
+	tusOwnerKey = "api_key_id"
+)
+
+// tus 断点续传的暂存区，首次使用时根据配置初始化
+var (
+	tusOnce    sync.Once
+	tusStore   *tus.Store
+	tusInitErr error
+)
+
+// tusEnabled 是否开启断点续传上传
+func tusEnabled() bool {
+	return global.AppConfig.Upload.Tus.Enabled
+}
+
+// tusExpiration 未完成的上传保留的时间
+func tusExpiration() time.Duration {
+	if d, err := time.ParseDuration(global.AppConfig.Upload.Tus.Expiration); err == nil && d > 0 {
+		return d
+	}
+	return defaultTusExpiration
+}
+
+// getTusStore 返回断点续传的暂存区，目录默认位于系统临时目录下
+func getTusStore() (*tus.Store, error) {
+	tusOnce.Do(func() {
+		dir := global.AppConfig.Upload.Tus.Dir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "goimage-tus")
+		}
+		tusStore, tusInitErr = tus.NewStore(dir, tusExpiration())
+	})
+	return tusStore, tusInitErr
+}
+
+// CleanupTusUploads 删除过期未完成的断点续传上传，由定时任务调用
+func CleanupTusUploads() {
+	store, err := getTusStore()
+	if err != nil {
+		logger.Error("断点续传暂存目录不可用: %v", err)
+		return
+	}
+	count, err := store.Cleanup()
+	if err != nil {
+		logger.Error("清理过期断点续传上传失败: %v", err)
+		return
+	}
+	if count > 0 {
+		logger.Info("已清理 %d 个过期的断点续传上传", count)
+	}
+}
+
+// setTusHeaders 设置协议版本和跨域相关的响应头，浏览器中的 tus 客户端需要读取这些头
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tus.Version)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, HEAD, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-API-Key, Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires, Upload-Metadata, X-Upload-URL")
+}
+
+// sendTusError 返回 JSON 格式的错误，与其他 API 保持一致
+func sendTusError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	sendJSONError(w, message, statusCode)
+}
+
+// checkTusRequest 检查断点续传是否开启以及客户端声明的协议版本
+func checkTusRequest(w http.ResponseWriter, r *http.Request) (*tus.Store, bool) {
+	setTusHeaders(w)
+	if !tusEnabled() {
+		sendTusError(w, "服务器未开启断点续传", http.StatusForbidden)
+		return nil, false
+	}
+	if r.Header.Get("Tus-Resumable") != tus.Version {
+		w.Header().Set("Tus-Version", tus.Version)
+		sendTusError(w, "不支持的 tus 协议版本", http.StatusPreconditionFailed)
+		return nil, false
+	}
+	store, err := getTusStore()
+	if err != nil {
+		logger.Error("断点续传暂存目录不可用: %v", err)
+		sendTusError(w, "服务器内部错误", http.StatusInternalServerError)
+		return nil, false
+	}
+	return store, true
+}
+
+// HandleTusOptions 返回服务端支持的 tus 版本、扩展和最大文件大小，同时用于浏览器的跨域预检
+func HandleTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if tusEnabled() {
+		w.Header().Set("Tus-Version", tus.Version)
+		w.Header().Set("Tus-Extension", "creation,creation-with-upload,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize(), 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTusCreate 创建上传，Upload-Metadata 中的 filename 为文件名，slug、short 等为上传参数
+// 请求体不为空时同时作为第一段数据写入
+func HandleTusCreate(w http.ResponseWriter, r *http.Request) {
+	store, ok := checkTusRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		sendTusError(w, "必须在创建时提供 Upload-Length", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		sendTusError(w, "无效的 Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > maxUploadSize() {
+		sendTusError(w, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := tus.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		sendTusError(w, "无效的 Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	// 提前校验上传参数，避免传完整个文件后才发现参数有误
+	if _, err := parseUploadOptions(tusUploadOptions(metadata).Get); err != nil {
+		sendTusError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 访问密码只以哈希形式保存到 .info 文件中，不让明文落盘
+	delete(metadata, tusPasswordHashKey)
+	if password := metadata["password"]; password != "" {
+		hash, err := utils.HashPassword(password)
+		if err != nil {
+			logger.Error("密码哈希计算失败: %v", err)
+			sendTusError(w, "密码处理失败", http.StatusInternalServerError)
+			return
+		}
+		metadata[tusPasswordHashKey] = hash
+	}
+	delete(metadata, "password")
+	delete(metadata, tusOwnerKey)
+	if apiKey := quota.KeyFromContext(r.Context()); apiKey != "" {
+		metadata[tusOwnerKey] = quota.KeyID(apiKey)
+	}
+
+	info, err := store.Create(length, metadata)
+	if err != nil {
+		logger.Error("创建断点续传上传失败: %v", err)
+		sendTusError(w, "创建上传失败", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("[%s] 创建断点续传上传，总长度 %d", info.ID, length)
+
+	w.Header().Set("Location", fmt.Sprintf("%s://%s/api/v1/tus/%s", requestScheme(r), r.Host, info.ID))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.Format(http.TimeFormat))
+
+	// creation-with-upload：创建请求中附带了第一段数据
+	if r.ContentLength != 0 && r.Header.Get("Content-Type") == tusOffsetContentType {
+		writeTusChunk(w, r, store, info.ID, 0, http.StatusCreated)
+		return
+	}
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleTusHead 返回已接收的长度，客户端据此从断点继续上传
+func HandleTusHead(w http.ResponseWriter, r *http.Request) {
+	store, ok := checkTusRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	info, err := getTusUpload(r, store, mux.Vars(r)["id"])
+	if err != nil {
+		if !errors.Is(err, tus.ErrNotFound) {
+			logger.Error("读取断点续传上传失败: %v", err)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.Format(http.TimeFormat))
+	if metadata := encodeTusMetadata(info.Metadata); metadata != "" {
+		w.Header().Set("Upload-Metadata", metadata)
+	}
+	if len(info.Result) > 0 {
+		w.Header().Set("X-Upload-URL", tusResultURL(info.Result))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleTusPatch 从 Upload-Offset 处追加数据，收到全部数据后按普通上传的流程保存文件
+func HandleTusPatch(w http.ResponseWriter, r *http.Request) {
+	store, ok := checkTusRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusOffsetContentType {
+		sendTusError(w, "Content-Type 必须为 "+tusOffsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		sendTusError(w, "无效的 Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	writeTusChunk(w, r, store, mux.Vars(r)["id"], offset, http.StatusNoContent)
+}
+
+// writeTusChunk 写入一段数据并返回新的偏移量，数据接收完整时保存文件
+func writeTusChunk(w http.ResponseWriter, r *http.Request, store *tus.Store, id string, offset int64, successCode int) {
+	unlock, err := store.Lock(id)
+	if err != nil {
+		sendTusError(w, "该上传正在被其他请求写入", http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	info, err := getTusUpload(r, store, id)
+	if err != nil {
+		if !errors.Is(err, tus.ErrNotFound) {
+			logger.Error("[%s] 读取断点续传上传失败: %v", id, err)
+		}
+		sendTusError(w, "上传不存在或已过期", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Expires", info.ExpiresAt.Format(http.TimeFormat))
+
+	// 上一次的最终响应丢失时，客户端可能重复提交最后一段
+	if len(info.Result) > 0 {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Length, 10))
+		w.Header().Set("X-Upload-URL", tusResultURL(info.Result))
+		w.WriteHeader(successCode)
+		return
+	}
+
+	// 服务器的读写超时针对普通请求，分块上传的单个请求允许更长的传输时间
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(tusPatchTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Warn("[%s] 设置读取超时失败: %v", id, err)
+	}
+	if err := rc.SetWriteDeadline(deadline.Add(global.UploadTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Warn("[%s] 设置写入超时失败: %v", id, err)
+	}
+
+	newOffset, err := store.Write(id, offset, r.Body)
+	switch {
+	case errors.Is(err, tus.ErrOffsetMismatch):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		sendTusError(w, "Upload-Offset 与已接收的长度不一致", http.StatusConflict)
+		return
+	case errors.Is(err, tus.ErrTooLarge):
+		sendTusError(w, "数据超过 Upload-Length 声明的长度", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		// 连接中断等错误，已写入的部分保留，客户端可通过 HEAD 获取新的偏移量后继续
+		logger.Warn("[%s] 写入分块数据中断，已接收 %d 字节: %v", id, newOffset, err)
+		sendTusError(w, "写入数据失败", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if newOffset < info.Length {
+		w.WriteHeader(successCode)
+		return
+	}
+
+	info.Offset = newOffset
+	imageResponse, uploadErr := finishTusUpload(r, store, info)
+	if uploadErr != nil {
+		sendTusError(w, uploadErr.Message, uploadErr.Code)
+		return
+	}
+	w.Header().Set("X-Upload-URL", imageResponse.URL)
+	w.WriteHeader(successCode)
+}
+
+// finishTusUpload 将接收完整的文件交给普通上传的流程保存
+// 成功后删除暂存数据，只保留结果供客户端查询；文件本身不合格时删除整个上传，
+// 服务端错误则保留数据，客户端在 Upload-Offset 等于总长度处重新 PATCH 即可重试保存
+func finishTusUpload(r *http.Request, store *tus.Store, info *tus.Info) (*ImageResponse, *uploadError) {
+	ctx, cancel := context.WithTimeout(r.Context(), global.UploadTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// 数据已经完整，等待空闲的上传名额而不是让客户端重传
+	release, ok := acquireUploadSlot(ctx, true)
+	if !ok {
+		return nil, &uploadError{Message: "服务器繁忙，请稍后再试", Code: http.StatusServiceUnavailable}
+	}
+	defer release()
+
+	imageResponse, uploadErr := storeTusFile(r, store, info)
+	if uploadErr != nil {
+		if uploadErr.Code >= http.StatusInternalServerError {
+			return nil, uploadErr
+		}
+		if err := store.Remove(info.ID); err != nil {
+			logger.Error("[%s] 删除断点续传上传失败: %v", info.ID, err)
+		}
+		return nil, uploadErr
+	}
+
+	result, err := json.Marshal(imageResponse)
+	if err == nil {
+		err = store.Finish(info.ID, result)
+	}
+	if err != nil {
+		logger.Error("[%s] 保存断点续传结果失败: %v", info.ID, err)
+	}
+	logger.Info("[%s] 断点续传上传完成: %s", info.ID, imageResponse.URL)
+	return imageResponse, nil
+}
+
+// storeTusFile 直接使用暂存的数据文件作为本地副本保存，不再复制
+func storeTusFile(r *http.Request, store *tus.Store, info *tus.Info) (*ImageResponse, *uploadError) {
+	file, err := store.Open(info.ID)
+	if err != nil {
+		logger.Error("[%s] 打开断点续传数据失败: %v", info.ID, err)
+		return nil, &uploadError{Message: "读取上传文件失败", Code: http.StatusInternalServerError}
+	}
+	spool, err := spoolExisting(file)
+	if err != nil {
+		logger.Error("[%s] 读取断点续传数据失败: %v", info.ID, err)
+		if cerr := file.Close(); cerr != nil {
+			logger.Error("[%s] failed to close tus data file: %v", info.ID, cerr)
+		}
+		return nil, &uploadError{Message: "读取上传文件失败", Code: http.StatusInternalServerError}
+	}
+	filename := info.Metadata["filename"]
+	if filename == "" {
+		filename = info.Metadata["name"]
+	}
+	upload := &uploadSource{
+		Spool:        spool,
+		Filename:     uploadName(filename, info.Metadata["filetype"]),
+		Options:      tusUploadOptions(info.Metadata),
+		PasswordHash: info.Metadata[tusPasswordHashKey],
+	}
+	defer upload.Close()
+
+	result, uploadErr := storeUpload(r.Context(), newUploadRequest(r, uuid.New().String(), upload))
+	if uploadErr != nil {
+		return nil, uploadErr
+	}
+	return result.imageResponse(), nil
+}
+
+// getTusUpload 读取上传的元信息，由其他 API Key 创建的上传视为不存在
+func getTusUpload(r *http.Request, store *tus.Store, id string) (*tus.Info, error) {
+	info, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	owner := info.Metadata[tusOwnerKey]
+	if apiKey := quota.KeyFromContext(r.Context()); owner != "" && (apiKey == "" || quota.KeyID(apiKey) != owner) {
+		return nil, tus.ErrNotFound
+	}
+	return info, nil
+}
+
+// HandleTusResult 返回已完成上传的保存结果，格式与 /api/v1/upload 的响应相同
+func HandleTusResult(w http.ResponseWriter, r *http.Request) {
+	store, ok := checkTusRequest(w, r)
+	if !ok {
+		return
+	}
+	info, err := getTusUpload(r, store, mux.Vars(r)["id"])
+	if err != nil {
+		sendTusError(w, "上传不存在或已过期", http.StatusNotFound)
+		return
+	}
+	if len(info.Result) == 0 {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		sendTusError(w, "上传尚未完成", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Message: "上传成功",
+		Data:    info.Result,
+	}); err != nil {
+		logger.Error("failed to write tus result response: %v", err)
+	}
+}
+
+// HandleTusDelete 取消上传并删除已接收的数据
+func HandleTusDelete(w http.ResponseWriter, r *http.Request) {
+	store, ok := checkTusRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	unlock, err := store.Lock(id)
+	if err != nil {
+		sendTusError(w, "该上传正在被其他请求写入", http.StatusLocked)
+		return
+	}
+	defer unlock()
+
+	if _, err := getTusUpload(r, store, id); err != nil {
+		sendTusError(w, "上传不存在或已过期", http.StatusNotFound)
+		return
+	}
+	if err := store.Remove(id); err != nil {
+		logger.Error("[%s] 删除断点续传上传失败: %v", id, err)
+		sendTusError(w, "删除上传失败", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusUploadOptions 将 Upload-Metadata 转换为与表单上传相同的参数
+func tusUploadOptions(metadata map[string]string) url.Values {
+	values := url.Values{}
+	for key, value := range metadata {
+		values.Set(key, value)
+	}
+	return values
+}
+
+// encodeTusMetadata 按 Upload-Metadata 的格式编码元数据，访问密码的哈希和创建者标识不会返回给客户端
+func encodeTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		if key != tusPasswordHashKey && key != tusOwnerKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// tusResultURL 从保存的结果中取出图片链接
+func tusResultURL(result json.RawMessage) string {
+	var imageResponse ImageResponse
+	if err := json.Unmarshal(result, &imageResponse); err != nil {
+		return ""
+	}
+	return imageResponse.URL
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/quota"
+	"hosting/internal/svg"
+	"hosting/internal/telegram"
+	"hosting/internal/utils"
+)
+
+// uploadError 上传失败时返回给客户端的提示信息和状态码
+type uploadError struct {
+	Message string
+	Code    int
+}
+
+// uploadRequest 一次上传的输入，与文件来自网页表单、API 还是其他入口无关
+type uploadRequest struct {
+	RequestID string
+	Source    *uploadSource
+	FormValue func(key string) string // 读取上传参数，Source.Options 为 nil 时使用
+	IPAddress string
+	UserAgent string
+	BaseURL   string // 公开链接的协议和域名，如 https://example.com
+	APIKey    string // 上传使用的 API Key，匿名上传为空
+}
+
+// newUploadRequest 从 HTTP 请求中取得客户端信息和上传参数
+func newUploadRequest(r *http.Request, requestID string, upload *uploadSource) *uploadRequest {
+	ipAddress := utils.ValidateIPAddress(r.RemoteAddr)
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		ipAddress = utils.ValidateIPAddress(forwardedFor)
+	}
+	return &uploadRequest{
+		RequestID: requestID,
+		Source:    upload,
+		FormValue: r.FormValue,
+		IPAddress: ipAddress,
+		UserAgent: utils.SanitizeUserAgent(r.Header.Get("User-Agent")),
+		BaseURL:   fmt.Sprintf("%s://%s", requestScheme(r), r.Host),
+		APIKey:    quota.KeyFromContext(r.Context()),
+	}
+}
+
+// uploadItem 已通过大小和类型校验、等待保存的文件，预处理钩子可以修改其中的内容
+type uploadItem struct {
+	Request     *uploadRequest
+	Spool       *spooledFile // 实际保存的内容，初始为上传的本地副本
+	Filename    string       // 清理后的文件名
+	ContentType string
+	FileExt     string
+	Options     uploadOptions
+
+	replaced []*spooledFile
+	quota    *quota.Reservation // 预占的 API Key 配额，上传成功时由 recordUploadQuota 提交
+}
+
+// replaceContent 用处理后的内容替换待保存的文件，生成的本地副本在上传结束后删除
+func (u *uploadItem) replaceContent(data []byte) error {
+	spool, err := spoolReader(bytes.NewReader(data), maxUploadSize())
+	if err != nil {
+		return err
+	}
+	u.replaced = append(u.replaced, spool)
+	u.Spool = spool
+	return nil
+}
+
+// close 删除预处理过程中生成的本地副本，并归还没有提交的配额
+func (u *uploadItem) close() {
+	for _, spool := range u.replaced {
+		spool.Close()
+	}
+	// 上传失败时归还预占的配额，已经提交的不受影响
+	if u.quota != nil {
+		u.quota.Release()
+	}
+}
+
+// uploadResult 上传成功后的结果
+type uploadResult struct {
+	URL         string // 完整的公开链接
+	ProxyURL    string // 数据库中记录的访问路径
+	Filename    string
+	ContentType string
+	Size        int64
+	UploadTime  string
+	ExpiresAt   string // 过期时间，未设置有效期时为空
+	Features    imageFeatures
+	Options     uploadOptions
+	Reused      bool // 文件已存在，直接返回了已有链接
+}
+
+// imageResponse 转换为 API 响应中的图片信息
+func (res *uploadResult) imageResponse() *ImageResponse {
+	return &ImageResponse{
+		URL:           res.URL,
+		Filename:      res.Filename,
+		ContentType:   res.ContentType,
+		Size:          res.Size,
+		UploadTime:    res.UploadTime,
+		Width:         res.Features.Width,
+		Height:        res.Features.Height,
+		BlurHash:      res.Features.BlurHash,
+		DominantColor: res.Features.DominantColor,
+		ExpiresAt:     res.ExpiresAt,
+		MaxViews:      res.Options.MaxViews,
+		Protected:     res.Options.protected(),
+		Duration:      res.Features.Duration,
+		Links:         linksMap(res.links()),
+	}
+}
+
+// links 按站点配置生成的各种链接格式
+func (res *uploadResult) links() []uploadLink {
+	return buildLinks(res.URL, res.Filename, res.ContentType)
+}
+
+// preUploadHook 在文件通过类型校验后、发送到 Telegram 前调用，可以修改文件内容，返回错误时终止上传
+type preUploadHook func(ctx context.Context, item *uploadItem) *uploadError
+
+// postUploadHook 在记录写入数据库后调用，不影响上传结果
+type postUploadHook func(ctx context.Context, item *uploadItem, result *uploadResult)
+
+// 所有入口共用的上传钩子，按顺序执行
+var (
+	preUploadHooks  = []preUploadHook{sanitizeSVGUpload, checkUploadQuota}
+	postUploadHooks = []postUploadHook{recordUploadQuota}
+)
+
+// maxUploadSize 单个文件的大小限制
+func maxUploadSize() int64 {
+	return int64(global.AppConfig.Site.MaxFileSize) * 1024 * 1024
+}
+
+// storeUpload 校验文件的大小和类型，执行预处理钩子，发送到 Telegram 并写入数据库
+// 网页、API、批量和断点续传上传都通过这里保存文件
+func storeUpload(ctx context.Context, req *uploadRequest) (*uploadResult, *uploadError) {
+	requestID := req.RequestID
+	upload := req.Source
+
+	// 检查文件大小
+	if upload.Spool.size > maxUploadSize() {
+		return nil, &uploadError{Message: fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), Code: http.StatusBadRequest}
+	}
+
+	// 根据接收时保留的文件头检查文件类型
+	contentType, fileExt, ok := resolveContentType(upload.Spool.header, upload.Filename)
+	if !ok {
+		if global.AppConfig.Upload.Files.Enabled {
+			return nil, &uploadError{Message: "不允许上传该类型的文件", Code: http.StatusBadRequest}
+		}
+		return nil, &uploadError{Message: "不支持的文件类型，仅支持JPG/JPEG, PNG, GIF, WebP, AVIF, HEIC/HEIF, BMP, TIFF, ICO, SVG图片和MP4, WebM, MOV视频", Code: http.StatusBadRequest}
+	}
+
+	// 解析自定义链接名、短链接等上传参数
+	formValue := req.FormValue
+	if upload.Options != nil {
+		formValue = upload.Options.Get
+	}
+	opts, err := parseUploadOptions(formValue)
+	if err != nil {
+		return nil, &uploadError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	if upload.PasswordHash != "" {
+		opts.PasswordHash = upload.PasswordHash
+	}
+
+	item := &uploadItem{
+		Request:     req,
+		Spool:       upload.Spool,
+		Filename:    utils.SanitizeFilename(upload.Filename),
+		ContentType: contentType,
+		FileExt:     fileExt,
+		Options:     opts,
+	}
+	defer item.close()
+
+	for _, hook := range preUploadHooks {
+		if uploadErr := hook(ctx, item); uploadErr != nil {
+			return nil, uploadErr
+		}
+	}
+
+	slug, err := reserveSlug(opts)
+	if errors.Is(err, errSlugTaken) {
+		return nil, &uploadError{Message: "自定义链接名已被占用", Code: http.StatusConflict}
+	}
+	if err != nil {
+		logger.Error("[%s] 生成链接名失败: %v", requestID, err)
+		return nil, &uploadError{Message: "生成链接失败", Code: http.StatusInternalServerError}
+	}
+
+	// 内容哈希在接收文件时已经计算，用于去重
+	fileHash := item.Spool.hash
+
+	// 提取尺寸、感知哈希、BlurHash 等图片特征
+	features := extractImageFeatures(item.Spool.Path(), contentType)
+
+	var duplicate *duplicateImage
+	if dedupMode() != dedupOff {
+		duplicate, err = findDuplicateImage(fileHash)
+		if err != nil {
+			logger.Warn("[%s] 查询重复文件失败: %v", requestID, err)
+		}
+	}
+
+	var fileID, thumbFileID, telegramURL, proxyURL string
+	var messageID int
+	reused := false
+
+	if duplicate != nil {
+		// 相同内容已存储过，直接复用 Telegram 中的文件
+		fileID = duplicate.FileID
+		thumbFileID = duplicate.ThumbFileID
+		telegramURL = duplicate.TelegramURL
+		// 指定了自定义链接时总是生成新记录
+		if dedupMode() == dedupReuse && duplicate.Reusable && !opts.needsOwnRecord() {
+			proxyURL = duplicate.ProxyURL
+			reused = true
+		}
+		logger.Info("[%s] 检测到重复上传，复用已存储文件 %s", requestID, duplicate.ProxyURL)
+	} else {
+		fileID, thumbFileID, messageID, err = sendToTelegram(item.Spool, contentType, fileExt, features.Duration)
+		if err != nil {
+			logger.Error("[%s] 发送到 Telegram 失败: %v", requestID, err)
+			return nil, &uploadError{Message: "上传到存储服务失败", Code: http.StatusInternalServerError}
+		}
+		telegramURL, err = global.Bot.GetFileDirectURL(fileID)
+		if err != nil {
+			logger.Error("[%s] 获取文件URL失败: %v", requestID, err)
+			return nil, &uploadError{Message: "获取文件URL失败", Code: http.StatusInternalServerError}
+		}
+	}
+
+	// 生成公开URL
+	if proxyURL == "" {
+		proxyURL = fmt.Sprintf("/file/%s%s", uuid.New().String(), fileExt)
+	}
+
+	result := &uploadResult{
+		URL:         req.BaseURL + publicPath(proxyURL, slug, fileExt, opts),
+		ProxyURL:    proxyURL,
+		Filename:    item.Filename,
+		ContentType: contentType,
+		Size:        item.Spool.size,
+		UploadTime:  time.Now().Format(time.RFC3339),
+		Features:    features,
+		Options:     opts,
+		Reused:      reused,
+	}
+	if opts.ExpiresIn > 0 {
+		result.ExpiresAt = time.Now().Add(opts.ExpiresIn).UTC().Format(time.RFC3339)
+	}
+
+	if reused {
+		result.UploadTime = duplicate.UploadTime
+	} else {
+		passwordHash, err := opts.passwordHash()
+		if err != nil {
+			logger.Error("[%s] 密码哈希计算失败: %v", requestID, err)
+			return nil, &uploadError{Message: "密码处理失败", Code: http.StatusInternalServerError}
+		}
+		err = db.WithDBTimeout(func(ctx context.Context) error {
+			_, err := global.DB.ExecContext(ctx, `
+				INSERT INTO images (
+					telegram_url,
+					proxy_url,
+					ip_address,
+					user_agent,
+					filename,
+					content_type,
+					file_id,
+					upload_time,
+					file_hash,
+					phash,
+					width,
+					height,
+					blurhash,
+					dominant_color,
+					slug,
+					is_private,
+					expires_at,
+					max_views,
+					message_id,
+					chat_id,
+					password_hash,
+					duration,
+					thumb_file_id,
+					api_key_id,
+					file_size
+				) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+				telegramURL,
+				proxyURL,
+				req.IPAddress,
+				req.UserAgent,
+				item.Filename,
+				contentType,
+				fileID,
+				result.UploadTime,
+				fileHash,
+				features.PHash,
+				features.Width,
+				features.Height,
+				features.BlurHash,
+				features.DominantColor,
+				sql.NullString{String: slug, Valid: slug != ""},
+				opts.Private,
+				opts.expiresAt(),
+				opts.MaxViews,
+				messageID,
+				global.AppConfig.Telegram.ChatID,
+				passwordHash,
+				features.Duration,
+				sql.NullString{String: thumbFileID, Valid: thumbFileID != ""},
+				apiKeyID(req.APIKey),
+				result.Size,
+			)
+			return err
+		})
+		if isSlugConflict(err) {
+			// 检查链接名之后、插入记录之前被其他请求抢先使用
+			logger.Warn("[%s] 链接名 %s 已被占用: %v", requestID, slug, err)
+			if opts.Slug != "" {
+				return nil, &uploadError{Message: "自定义链接名已被占用", Code: http.StatusConflict}
+			}
+			return nil, &uploadError{Message: "短链接已被占用，请重试", Code: http.StatusConflict}
+		}
+		if err != nil {
+			logger.Error("[%s] 数据库插入失败: %v", requestID, err)
+			return nil, &uploadError{Message: "保存记录失败", Code: http.StatusInternalServerError}
+		}
+	}
+
+	for _, hook := range postUploadHooks {
+		hook(ctx, item, result)
+	}
+	return result, nil
+}
+
+// sendToTelegram 根据文件类型选择发送方式，返回文件ID、封面帧文件ID和消息ID
+func sendToTelegram(spool *spooledFile, contentType, fileExt string, duration float64) (string, string, int, error) {
+	chatID := global.AppConfig.Telegram.ChatID
+
+	// 对于图片文件（JPG/PNG/WebP），使用 NewPhoto 发送以确保在 Telegram 中正确显示
+	// 注意：Telegram 会将动态 WebP 转为静态图片，这是 Telegram 的限制
+	if telegram.SendAsPhoto(contentType) {
+		message, err := global.Bot.Send(tgbotapi.NewPhoto(chatID, telegramFile(spool, fileExt)))
+		if err != nil {
+			return "", "", 0, err
+		}
+		if len(message.Photo) == 0 {
+			return "", "", 0, errors.New("telegram did not return a photo")
+		}
+		// 获取最大尺寸的照片文件ID
+		return message.Photo[len(message.Photo)-1].FileID, "", message.MessageID, nil
+	}
+
+	if telegram.SendAsVideo(contentType) {
+		// MP4 以视频消息发送，Telegram 会生成封面帧并支持边下边播
+		videoMsg := tgbotapi.NewVideo(chatID, telegramFile(spool, fileExt))
+		videoMsg.Duration = int(duration)
+		videoMsg.SupportsStreaming = true
+		message, err := global.Bot.Send(videoMsg)
+		if err != nil {
+			return "", "", 0, err
+		}
+		if message.Video == nil {
+			return "", "", 0, errors.New("telegram did not return a video")
+		}
+		var thumbFileID string
+		if message.Video.Thumbnail != nil {
+			thumbFileID = message.Video.Thumbnail.FileID
+		}
+		return message.Video.FileID, thumbFileID, message.MessageID, nil
+	}
+
+	// 对于 GIF 和其他格式，使用 Document 方式
+	message, err := global.Bot.Send(tgbotapi.NewDocument(chatID, telegramFile(spool, fileExt)))
+	if err != nil {
+		return "", "", 0, err
+	}
+	if message.Document == nil {
+		return "", "", 0, errors.New("telegram did not return a document")
+	}
+	var thumbFileID string
+	if message.Document.Thumbnail != nil {
+		thumbFileID = message.Document.Thumbnail.FileID
+	}
+	return message.Document.FileID, thumbFileID, message.MessageID, nil
+}
+
+// sanitizeSVGUpload 保存前移除 SVG 中的脚本、事件处理属性和外部引用
+func sanitizeSVGUpload(_ context.Context, item *uploadItem) *uploadError {
+	if item.ContentType != "image/svg+xml" {
+		return nil
+	}
+	sanitized, err := svg.Sanitize(item.Spool.Reader())
+	if err != nil {
+		logger.Warn("[%s] SVG 清理失败: %v", item.Request.RequestID, err)
+		return &uploadError{Message: "无效的SVG文件", Code: http.StatusBadRequest}
+	}
+	if err := item.replaceContent(sanitized); err != nil {
+		logger.Error("[%s] 保存清理后的SVG失败: %v", item.Request.RequestID, err)
+		return &uploadError{Message: "保存上传文件失败", Code: http.StatusInternalServerError}
+	}
+	return nil
+}
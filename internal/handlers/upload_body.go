@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/utils"
+)
+
+// defaultUploadName 请求中没有提供文件名时使用的名称
+const defaultUploadName = "upload"
+
+// base64UploadRequest /api/v1/upload/base64 的请求体
+type base64UploadRequest struct {
+	Filename  string `json:"filename"`
+	Content   string `json:"content"` // base64 编码的文件内容，也可以是 data:image/png;base64,... 形式的 data URI
+	Slug      string `json:"slug"`
+	Short     bool   `json:"short"`
+	Private   bool   `json:"private"`
+	ExpiresIn string `json:"expiresIn"`
+	MaxViews  int    `json:"maxViews"`
+	Password  string `json:"password"`
+}
+
+// options 转换为与表单上传相同的参数，复用同一套校验
+func (req base64UploadRequest) options() url.Values {
+	values := url.Values{}
+	values.Set("slug", req.Slug)
+	values.Set("short", strconv.FormatBool(req.Short))
+	values.Set("private", strconv.FormatBool(req.Private))
+	values.Set("expiresIn", req.ExpiresIn)
+	if req.MaxViews != 0 {
+		values.Set("maxViews", strconv.Itoa(req.MaxViews))
+	}
+	values.Set("password", req.Password)
+	return values
+}
+
+// HandleAPIUploadBase64 接收 JSON 请求体中 base64 编码的文件，适用于无法构造 multipart 请求的脚本和 Webhook
+func HandleAPIUploadBase64(w http.ResponseWriter, r *http.Request) {
+	serveAPIUpload(w, r, func(w http.ResponseWriter, r *http.Request, requestID string) (*uploadSource, string, int) {
+		// base64 编码后体积增加约三分之一
+		maxSize := maxUploadSize()
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize/3*4+4+maxOptionsBodySize)
+
+		var req base64UploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest
+			}
+			return nil, "无效的JSON请求体", http.StatusBadRequest
+		}
+		if req.Content == "" {
+			return nil, "缺少 content 字段", http.StatusBadRequest
+		}
+
+		data, declaredType, err := decodeBase64Content(req.Content)
+		if err != nil {
+			logger.Warn("[%s] base64 内容解码失败: %v", requestID, err)
+			return nil, "content 不是有效的 base64 或 data URI", http.StatusBadRequest
+		}
+		if len(data) == 0 {
+			return nil, "文件内容为空", http.StatusBadRequest
+		}
+
+		spool, err := spoolReader(bytes.NewReader(data), maxSize)
+		if isTooLarge(err) {
+			return nil, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest
+		}
+		if err != nil {
+			logger.Error("[%s] 保存上传文件失败: %v", requestID, err)
+			return nil, "保存上传文件失败", http.StatusInternalServerError
+		}
+		return &uploadSource{
+			Spool:    spool,
+			Filename: uploadName(req.Filename, declaredType),
+			Options:  req.options(),
+		}, "", 0
+	})
+}
+
+// HandleAPIUploadRaw 以整个请求体作为文件内容，文件名通过 X-Filename 请求头或 filename 查询参数传递，
+// 其他上传参数通过查询参数传递
+func HandleAPIUploadRaw(w http.ResponseWriter, r *http.Request) {
+	serveAPIUpload(w, r, func(w http.ResponseWriter, r *http.Request, requestID string) (*uploadSource, string, int) {
+		// 请求体直接写入本地副本，不在内存中缓冲
+		maxSize := maxUploadSize()
+		spool, err := spoolReader(r.Body, maxSize)
+		if isTooLarge(err) {
+			return nil, fmt.Sprintf("文件大小超过限制 (%dMB)", global.AppConfig.Site.MaxFileSize), http.StatusBadRequest
+		}
+		if err != nil {
+			logger.Warn("[%s] 读取请求体失败: %v", requestID, err)
+			return nil, "读取请求体失败", http.StatusBadRequest
+		}
+		if spool.size == 0 {
+			spool.Close()
+			return nil, "请求体为空", http.StatusBadRequest
+		}
+
+		declaredType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return &uploadSource{
+			Spool:    spool,
+			Filename: uploadName(rawUploadFilename(r), declaredType),
+			// 请求体是文件本身，不能按表单解析，参数只从查询字符串中读取
+			Options: r.URL.Query(),
+		}, "", 0
+	})
+}
+
+// decodeBase64Content 解码 base64 字符串或 data URI，返回文件内容和 data URI 中声明的类型
+// 同时兼容标准和 URL 安全的字符集，允许省略填充和包含换行
+func decodeBase64Content(content string) ([]byte, string, error) {
+	var declaredType string
+	if rest, ok := strings.CutPrefix(content, "data:"); ok {
+		meta, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(strings.ToLower(meta), ";base64") {
+			return nil, "", errors.New("data URI is not base64 encoded")
+		}
+		declaredType = strings.ToLower(strings.TrimSpace(strings.SplitN(meta, ";", 2)[0]))
+		content = payload
+	}
+
+	content = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n', '=':
+			return -1
+		case '-':
+			return '+'
+		case '_':
+			return '/'
+		}
+		return r
+	}, content)
+
+	data, err := base64.RawStdEncoding.DecodeString(content)
+	return data, declaredType, err
+}
+
+// rawUploadFilename 依次从 X-Filename 请求头、Content-Disposition 和 filename 查询参数中读取文件名
+// 请求头只能包含 ASCII，非 ASCII 文件名需要进行 URL 编码
+func rawUploadFilename(r *http.Request) string {
+	if name := r.Header.Get("X-Filename"); name != "" {
+		if decoded, err := url.PathUnescape(name); err == nil {
+			return decoded
+		}
+		return name
+	}
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return r.URL.Query().Get("filename")
+}
+
+// uploadName 返回上传使用的文件名，未提供时根据声明的类型生成带扩展名的默认名称
+func uploadName(filename, declaredType string) string {
+	if strings.TrimSpace(filename) != "" {
+		return filename
+	}
+	if ext, ok := utils.GetFileExtension(declaredType); ok {
+		return defaultUploadName + ext
+	}
+	return defaultUploadName
+}
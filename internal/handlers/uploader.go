@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/global"
+	"hosting/internal/logger"
+	"hosting/internal/utils"
+)
+
+// sharexVersion 生成的 .sxcu 文件使用的格式版本，{json:...} 语法需要 ShareX 13.7 及以上
+const sharexVersion = "15.0.0"
+
+// uploaderResponse 上传工具兼容接口的响应，字段全部在顶层，工具中直接填写 url 或 error 即可取值
+type uploaderResponse struct {
+	URL          string            `json:"url,omitempty"`
+	ThumbnailURL string            `json:"thumbnail_url,omitempty"`
+	Filename     string            `json:"filename,omitempty"`
+	Links        map[string]string `json:"links,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// uploaderResponder 按上传工具需要的格式写入响应，text 为 true 时只返回纯文本的链接或错误信息
+type uploaderResponder struct {
+	text bool
+}
+
+func (u uploaderResponder) success(w http.ResponseWriter, requestID string, result *uploadResult) {
+	if u.text {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := fmt.Fprint(w, result.URL); err != nil {
+			logger.Error("[%s] failed to write uploader response: %v", requestID, err)
+		}
+		return
+	}
+
+	response := uploaderResponse{
+		URL:      result.URL,
+		Filename: result.Filename,
+		Links:    linksMap(result.links()),
+	}
+	if isImageType(result.ContentType) {
+		response.ThumbnailURL = result.URL
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error("[%s] failed to write uploader response: %v", requestID, err)
+	}
+}
+
+func (u uploaderResponder) failure(w http.ResponseWriter, message string, statusCode int) {
+	if u.text {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(statusCode)
+		if _, err := fmt.Fprint(w, message); err != nil {
+			logger.Error("failed to write uploader error response: %v", err)
+		}
+		return
+	}
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(uploaderResponse{Error: message}); err != nil {
+		logger.Error("failed to write uploader error response: %v", err)
+	}
+}
+
+// HandleUploaderUpload 供 ShareX、PicGo、Typora 等上传工具使用的上传接口
+// 文件字段可以是 image 或 file，成功时返回顶层的 url，失败时返回 error；format=text 时只返回链接文本
+func HandleUploaderUpload(w http.ResponseWriter, r *http.Request) {
+	respond := uploaderResponder{text: r.URL.Query().Get("format") == "text"}
+	serveAPIUploadAs(w, r, multipartSource([]string{"image", "file"}), respond)
+}
+
+// sharexConfig ShareX 自定义上传器的 .sxcu 配置文件
+type sharexConfig struct {
+	Version         string
+	Name            string
+	DestinationType string
+	RequestMethod   string
+	RequestURL      string
+	Headers         map[string]string `json:",omitempty"`
+	Body            string
+	FileFormName    string
+	URL             string
+	ThumbnailURL    string
+	ErrorMessage    string
+}
+
+// picgoConfig PicGo-Core 的配置文件，使用 picgo-plugin-web-uploader 插件，Typora 通过 PicGo-Core 上传时读取同一个文件
+type picgoConfig struct {
+	PicBed struct {
+		Uploader    string           `json:"uploader"`
+		Current     string           `json:"current"`
+		WebUploader picgoWebUploader `json:"web-uploader"`
+	} `json:"picBed"`
+	PicgoPlugins map[string]bool `json:"picgoPlugins"`
+}
+
+// picgoWebUploader web-uploader 插件的配置，customHeader 和 customBody 为 JSON 字符串
+type picgoWebUploader struct {
+	URL          string `json:"url"`
+	ParamName    string `json:"paramName"`
+	JSONPath     string `json:"jsonPath"`
+	CustomHeader string `json:"customHeader"`
+	CustomBody   string `json:"customBody"`
+}
+
+// HandleAdminUploaderConfig 生成预先填好本站地址和 API Key 的上传工具配置文件
+// 路径中的 app 为 sharex 或 picgo，查询参数 key 为使用第几个 API Key，默认第一个
+func HandleAdminUploaderConfig(w http.ResponseWriter, r *http.Request) {
+	keys := global.AppConfig.Security.APIKeys
+	var apiKey string
+	if len(keys) > 0 {
+		index := 0
+		if value := r.URL.Query().Get("key"); value != "" {
+			var err error
+			index, err = strconv.Atoi(value)
+			if err != nil || index < 0 || index >= len(keys) {
+				http.Error(w, "Invalid API key index", http.StatusBadRequest)
+				return
+			}
+		}
+		apiKey = keys[index]
+	}
+	if apiKey == "" && global.AppConfig.Security.RequireAPIKey {
+		http.Error(w, "No API key configured", http.StatusBadRequest)
+		return
+	}
+
+	uploadURL := fmt.Sprintf("%s://%s/api/v1/upload/simple", requestScheme(r), r.Host)
+	name := global.AppConfig.Site.Name
+	if name == "" {
+		name = r.Host
+	}
+
+	var config any
+	var filename string
+	switch mux.Vars(r)["app"] {
+	case "sharex":
+		sharex := sharexConfig{
+			Version:         sharexVersion,
+			Name:            name,
+			DestinationType: "ImageUploader, FileUploader",
+			RequestMethod:   "POST",
+			RequestURL:      uploadURL,
+			Body:            "MultipartFormData",
+			FileFormName:    "image",
+			URL:             "{json:url}",
+			ThumbnailURL:    "{json:thumbnail_url}",
+			ErrorMessage:    "{json:error}",
+		}
+		if apiKey != "" {
+			sharex.Headers = map[string]string{"X-API-Key": apiKey}
+		}
+		config, filename = sharex, name+".sxcu"
+	case "picgo":
+		picgo := picgoConfig{PicgoPlugins: map[string]bool{"picgo-plugin-web-uploader": true}}
+		picgo.PicBed.Uploader = "web-uploader"
+		picgo.PicBed.Current = "web-uploader"
+		picgo.PicBed.WebUploader = picgoWebUploader{
+			URL:       uploadURL,
+			ParamName: "image",
+			JSONPath:  "url",
+		}
+		if apiKey != "" {
+			header, err := json.Marshal(map[string]string{"X-API-Key": apiKey})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			picgo.PicBed.WebUploader.CustomHeader = string(header)
+		}
+		config, filename = picgo, "config.json"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 文件中包含 API Key，不允许缓存
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", filename))
+	if _, err := w.Write(data); err != nil {
+		log.Printf("failed to write uploader config: %v", err)
+	}
+}
+
+// uploaderKeyLabels 管理页面中选择 API Key 时显示的遮蔽后的名称
+func uploaderKeyLabels() []string {
+	labels := make([]string, 0, len(global.AppConfig.Security.APIKeys))
+	for _, key := range global.AppConfig.Security.APIKeys {
+		labels = append(labels, utils.MaskAPIKey(key))
+	}
+	return labels
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+)
+
+// HandleAdminPoster 返回 Telegram 为视频生成的封面帧，供管理页面的列表预览
+// Telegram 的文件链接中包含机器人令牌，因此由服务端代理而不是重定向
+func HandleAdminPoster(w http.ResponseWriter, r *http.Request) {
+	var thumbFileID sql.NullString
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		return global.DB.QueryRowContext(ctx,
+			"SELECT thumb_file_id FROM images WHERE id = ?", mux.Vars(r)["id"],
+		).Scan(&thumbFileID)
+	})
+	if err != nil || !thumbFileID.Valid || thumbFileID.String == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	fileURL, err := GetTelegramFileURL(thumbFileID.String)
+	if err != nil {
+		http.Error(w, "Failed to get poster URL", http.StatusBadGateway)
+		log.Printf("failed to get poster URL: %v", err)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(r.Context(), "GET", fileURL, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, "Failed to fetch poster", http.StatusBadGateway)
+		log.Printf("failed to fetch poster: %v", err)
+		return
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("failed to close response body: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, "Failed to fetch poster", http.StatusBadGateway)
+		return
+	}
+
+	// Telegram 生成的缩略图均为 JPEG
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if resp.ContentLength > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Error streaming poster: %v", err)
+	}
+}
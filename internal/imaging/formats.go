@@ -0,0 +1,236 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DetectContentType 根据文件头识别图片和视频类型
+// 在 http.DetectContentType 的基础上补充 AVIF/HEIF、TIFF、SVG 和 MOV 的识别，BMP 和 ICO 也在这里统一处理
+func DetectContentType(data []byte) string {
+	switch {
+	case len(data) >= 4 && (bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))):
+		return "image/tiff"
+	case len(data) >= 6 && bytes.HasPrefix(data, []byte("\x00\x00\x01\x00")) && data[4]|data[5] != 0:
+		return "image/x-icon"
+	case len(data) >= 26 && bytes.HasPrefix(data, []byte("BM")):
+		return "image/bmp"
+	}
+	if contentType := isobmffImageType(data); contentType != "" {
+		return contentType
+	}
+	if contentType := isobmffVideoType(data); contentType != "" {
+		return contentType
+	}
+	if looksLikeSVG(data) {
+		return "image/svg+xml"
+	}
+	return http.DetectContentType(data)
+}
+
+// looksLikeSVG 跳过 XML 声明、注释和 DOCTYPE 后判断根元素是否为 svg
+// 这里只做初步判断，文档是否有效由上传时的 SVG 清理步骤校验
+func looksLikeSVG(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	for {
+		data = bytes.TrimLeft(data, " \t\r\n")
+		var end []byte
+		switch {
+		case bytes.HasPrefix(data, []byte("<?")):
+			end = []byte("?>")
+		case bytes.HasPrefix(data, []byte("<!--")):
+			end = []byte("-->")
+		case bytes.HasPrefix(data, []byte("<!")):
+			end = []byte(">")
+		default:
+			return len(data) > 4 && bytes.HasPrefix(data, []byte("<svg")) &&
+				strings.IndexByte(" \t\r\n>/", data[4]) >= 0
+		}
+		idx := bytes.Index(data, end)
+		if idx < 0 {
+			return false
+		}
+		data = data[idx+len(end):]
+	}
+}
+
+// isobmffImageType 识别基于 ISOBMFF 容器的 AVIF 和 HEIF 图片，检查 ftyp 盒中的主品牌和兼容品牌
+func isobmffImageType(data []byte) string {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		size = len(data)
+	}
+
+	brands := []string{string(data[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(data[i:i+4]))
+	}
+
+	var heic, heif bool
+	for _, brand := range brands {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs":
+			heic = true
+		case "mif1", "msf1":
+			heif = true
+		}
+	}
+	switch {
+	case heic:
+		return "image/heic"
+	case heif:
+		return "image/heif"
+	}
+	return ""
+}
+
+// isobmffVideoType 根据 ftyp 盒的主品牌识别 MP4 和 QuickTime 视频
+// 必须在 isobmffImageType 之后调用，AVIF/HEIF 也使用 mif1、iso8 等通用品牌
+func isobmffVideoType(data []byte) string {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+	switch brand := string(data[8:12]); {
+	case brand == "qt  ":
+		return "video/quicktime"
+	case strings.HasPrefix(brand, "iso"), strings.HasPrefix(brand, "mp4"), strings.HasPrefix(brand, "avc"),
+		strings.HasPrefix(brand, "dash"), brand == "M4V ", brand == "M4VH", brand == "MSNV", brand == "f4v ":
+		return "video/mp4"
+	}
+	return ""
+}
+
+// headerSize 从 BMP、ICO 和 AVIF/HEIF 的文件头中读取尺寸
+func headerSize(b []byte) (int, int, bool) {
+	switch {
+	case len(b) >= 26 && bytes.HasPrefix(b, []byte("BM")):
+		return bmpSize(b)
+	case len(b) >= 6 && bytes.HasPrefix(b, []byte("\x00\x00\x01\x00")):
+		return icoSize(b)
+	case isobmffImageType(b) != "":
+		return ispeSize(b)
+	}
+	return 0, 0, false
+}
+
+// bmpSize 解析 BMP 的 DIB 头，兼容 OS/2 的 12 字节头
+func bmpSize(b []byte) (int, int, bool) {
+	dibSize := binary.LittleEndian.Uint32(b[14:18])
+	if dibSize == 12 {
+		return int(binary.LittleEndian.Uint16(b[18:20])), int(binary.LittleEndian.Uint16(b[20:22])), true
+	}
+	if dibSize < 40 {
+		return 0, 0, false
+	}
+	w := int(int32(binary.LittleEndian.Uint32(b[18:22])))
+	h := int(int32(binary.LittleEndian.Uint32(b[22:26])))
+	// 高度为负数表示自上而下存储
+	if h < 0 {
+		h = -h
+	}
+	return w, h, w > 0 && h > 0
+}
+
+// icoSize 返回 ICO 中最大的图标尺寸，宽高字段为 0 时表示 256
+func icoSize(b []byte) (int, int, bool) {
+	count := int(binary.LittleEndian.Uint16(b[4:6]))
+	var width, height int
+	for i := 0; i < count && 6+i*16+2 <= len(b); i++ {
+		entry := b[6+i*16:]
+		w, h := int(entry[0]), int(entry[1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		if w*h > width*height {
+			width, height = w, h
+		}
+	}
+	return width, height, width > 0
+}
+
+// ispeSize 在 AVIF/HEIF 文件头中查找 ispe（图像空间尺寸）属性，存在多个时取面积最大的一个
+func ispeSize(b []byte) (int, int, bool) {
+	var width, height int
+	for offset := 0; ; {
+		i := bytes.Index(b[offset:], []byte("ispe"))
+		if i < 0 {
+			break
+		}
+		i += offset
+		// 盒类型之后依次为 4 字节的 version/flags、宽度和高度
+		if i+16 > len(b) {
+			break
+		}
+		w := int(binary.BigEndian.Uint32(b[i+8 : i+12]))
+		h := int(binary.BigEndian.Uint32(b[i+12 : i+16]))
+		if w*h > width*height {
+			width, height = w, h
+		}
+		offset = i + 4
+	}
+	return width, height, width > 0 && height > 0
+}
+
+// tiffSize 读取 TIFF 第一个 IFD 中的 ImageWidth 和 ImageLength 标签
+func tiffSize(r io.ReaderAt) (int, int, bool) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, 0, false
+	}
+
+	var order binary.ByteOrder
+	switch string(header[0:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0, 0, false
+	}
+
+	ifdOffset := int64(order.Uint32(header[4:8]))
+	countBuf := make([]byte, 2)
+	if _, err := r.ReadAt(countBuf, ifdOffset); err != nil {
+		return 0, 0, false
+	}
+	count := int(order.Uint16(countBuf))
+	entries := make([]byte, count*12)
+	if _, err := r.ReadAt(entries, ifdOffset+2); err != nil {
+		return 0, 0, false
+	}
+
+	var width, height int
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : i*12+12]
+		tag := order.Uint16(entry[0:2])
+		if tag != 256 && tag != 257 {
+			continue
+		}
+		var value int
+		switch order.Uint16(entry[2:4]) {
+		case 3: // SHORT
+			value = int(order.Uint16(entry[8:10]))
+		case 4: // LONG
+			value = int(order.Uint32(entry[8:12]))
+		default:
+			continue
+		}
+		if tag == 256 {
+			width = value
+		} else {
+			height = value
+		}
+	}
+	return width, height, width > 0 && height > 0
+}
@@ -0,0 +1,180 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // 注册 GIF 解码器
+	_ "image/jpeg" // 注册 JPEG 解码器
+	_ "image/png"  // 注册 PNG 解码器
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+// DecodeFile 解码图片文件，仅支持标准库可解码的 JPEG/PNG/GIF（GIF 取第一帧）
+func DecodeFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Printf("failed to close image file %s: %v", path, cerr)
+		}
+	}()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// DecodeConfigFile 读取图片尺寸，标准库无法识别的 WebP、BMP、TIFF、ICO 和 AVIF/HEIF 通过解析文件头获取
+func DecodeConfigFile(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Printf("failed to close image file %s: %v", path, cerr)
+		}
+	}()
+
+	// AVIF/HEIF 的尺寸信息位于 meta 盒中，通常在文件开头几百字节内
+	header := make([]byte, 4096)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, 0, err
+	}
+	if w, h, ok := webpSize(header[:n]); ok {
+		return w, h, nil
+	}
+	if w, h, ok := headerSize(header[:n]); ok {
+		return w, h, nil
+	}
+	if w, h, ok := tiffSize(file); ok {
+		return w, h, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// webpSize 从 WebP 文件头中解析宽高，支持 VP8、VP8L 和 VP8X 三种格式
+func webpSize(b []byte) (int, int, bool) {
+	if len(b) < 30 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+
+	switch string(b[12:16]) {
+	case "VP8X":
+		w := 1 + (int(b[24]) | int(b[25])<<8 | int(b[26])<<16)
+		h := 1 + (int(b[27]) | int(b[28])<<8 | int(b[29])<<16)
+		return w, h, true
+	case "VP8L":
+		if b[20] != 0x2f {
+			return 0, 0, false
+		}
+		w := 1 + (int(b[21]) | int(b[22]&0x3f)<<8)
+		h := 1 + (int(b[22]>>6) | int(b[23])<<2 | int(b[24]&0x0f)<<10)
+		return w, h, true
+	case "VP8 ":
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			return 0, 0, false
+		}
+		w := int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff)
+		return w, h, true
+	}
+	return 0, 0, false
+}
+
+// CanDecode 判断指定 MIME 类型是否可以被解码用于计算图片特征
+func CanDecode(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// DHash 计算 64 位差异哈希（dHash）：
+// 将图片缩放为 9x8 的灰度图，逐行比较相邻像素亮度，左侧更亮时该位为 1
+func DHash(img image.Image) uint64 {
+	gray := resizeGray(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y*9+x] > gray[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance 计算两个哈希之间不同的位数
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash 将哈希格式化为 16 位十六进制字符串用于存储
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash 解析 FormatHash 生成的字符串
+func ParseHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// resizeGray 使用区域平均将图片缩放为 width x height 的灰度值矩阵
+func resizeGray(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	out := make([]float64, width*height)
+	if srcW == 0 || srcH == 0 {
+		return out
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(bounds.Min.Y+(y+1)*srcH/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(bounds.Min.X+(x+1)*srcW/width, x0+1)
+
+			// 大图只对区域内的部分像素采样，避免逐像素计算过慢
+			stepX := max((x1-x0)/16, 1)
+			stepY := max((y1-y0)/16, 1)
+
+			var sum float64
+			var count int
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					sum += luminance(img.At(sx, sy))
+					count++
+				}
+			}
+			out[y*width+x] = sum / float64(count)
+		}
+	}
+	return out
+}
+
+// luminance 计算像素的感知亮度
+func luminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+}
@@ -0,0 +1,289 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+)
+
+// VideoInfo 视频的尺寸和时长
+type VideoInfo struct {
+	Width    int
+	Height   int
+	Duration float64 // 秒
+}
+
+// errNoVideoInfo 文件中没有找到视频信息
+var errNoVideoInfo = errors.New("video metadata not found")
+
+// IsVideo 判断 MIME 类型是否为支持的视频格式
+func IsVideo(contentType string) bool {
+	switch contentType {
+	case "video/mp4", "video/webm", "video/quicktime":
+		return true
+	}
+	return false
+}
+
+// ReadVideoInfo 读取 MP4/MOV 的 moov 盒或 WebM 的 EBML 头，获取视频尺寸和时长
+func ReadVideoInfo(path, contentType string) (VideoInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			log.Printf("failed to close video file %s: %v", path, cerr)
+		}
+	}()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return VideoInfo{}, err
+	}
+
+	var info VideoInfo
+	switch contentType {
+	case "video/mp4", "video/quicktime":
+		err = readMP4Boxes(file, 0, stat.Size(), &info, 0)
+	case "video/webm":
+		err = readEBML(file, 0, stat.Size(), &info, 0)
+	default:
+		return VideoInfo{}, errNoVideoInfo
+	}
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if info.Width == 0 && info.Duration == 0 {
+		return VideoInfo{}, errNoVideoInfo
+	}
+	return info, nil
+}
+
+// maxMP4Depth 进入 moov/trak 的最大层数，正常文件只有 moov → trak 两层，防止构造的深层嵌套耗尽栈空间
+const maxMP4Depth = 4
+
+// readMP4Boxes 遍历 [start, end) 范围内的盒，进入 moov/trak 读取 mvhd 和 tkhd
+func readMP4Boxes(r io.ReaderAt, start, end int64, info *VideoInfo, depth int) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			// 盒一直延伸到文件末尾
+			size = end - offset
+		case 1:
+			// 64 位长度
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || offset+size > end {
+			return nil
+		}
+
+		body := offset + headerLen
+		switch boxType {
+		case "moov", "trak":
+			if depth < maxMP4Depth {
+				if err := readMP4Boxes(r, body, offset+size, info, depth+1); err != nil {
+					return err
+				}
+			}
+		case "mvhd":
+			readMVHD(r, body, info)
+		case "tkhd":
+			readTKHD(r, body, info)
+		}
+		offset += size
+	}
+	return nil
+}
+
+// readMVHD 从 mvhd 盒读取时间刻度和总时长
+func readMVHD(r io.ReaderAt, body int64, info *VideoInfo) {
+	buf := make([]byte, 32)
+	if _, err := r.ReadAt(buf, body); err != nil {
+		return
+	}
+	var timescale, duration uint64
+	if buf[0] == 1 {
+		// version 1：创建和修改时间为 64 位
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale > 0 && duration != math.MaxUint32 && duration != math.MaxUint64 {
+		info.Duration = float64(duration) / float64(timescale)
+	}
+}
+
+// readTKHD 从 tkhd 盒末尾读取 16.16 定点数表示的宽高，取第一个有画面的轨道
+func readTKHD(r io.ReaderAt, body int64, info *VideoInfo) {
+	if info.Width > 0 {
+		return
+	}
+	version := make([]byte, 1)
+	if _, err := r.ReadAt(version, body); err != nil {
+		return
+	}
+	// version 0 的 tkhd 共 84 字节，version 1 为 96 字节，宽高位于最后 8 字节
+	sizeOffset := int64(76)
+	if version[0] == 1 {
+		sizeOffset = 88
+	}
+	buf := make([]byte, 8)
+	if _, err := r.ReadAt(buf, body+sizeOffset); err != nil {
+		return
+	}
+	width := int(binary.BigEndian.Uint32(buf[0:4]) >> 16)
+	height := int(binary.BigEndian.Uint32(buf[4:8]) >> 16)
+	if width > 0 && height > 0 {
+		info.Width, info.Height = width, height
+	}
+}
+
+// WebM/Matroska 中用到的 EBML 元素 ID
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+)
+
+// readEBML 遍历 [start, end) 范围内的 EBML 元素，读取时长和第一个视频轨道的尺寸
+func readEBML(r io.ReaderAt, start, end int64, info *VideoInfo, depth int) error {
+	timecodeScale := 1000000.0 // 默认 1ms
+	var rawDuration float64
+
+	for offset := start; offset < end; {
+		id, idLen, err := readVint(r, offset, true)
+		if err != nil {
+			return nil
+		}
+		size, sizeLen, err := readVint(r, offset+int64(idLen), false)
+		if err != nil {
+			return nil
+		}
+		body := offset + int64(idLen+sizeLen)
+		if size < 0 || body+size > end {
+			// 未知长度（直播流）的元素延伸到父元素末尾
+			size = end - body
+		}
+
+		switch id {
+		case ebmlCluster:
+			// 媒体数据开始，后面不会再有头部信息
+			return nil
+		case ebmlSegment, ebmlInfo, ebmlTracks, ebmlTrackEntry, ebmlVideo:
+			if depth < 8 {
+				if err := readEBML(r, body, body+size, info, depth+1); err != nil {
+					return err
+				}
+			}
+		case ebmlTimecodeScale:
+			timecodeScale = float64(readUint(r, body, size))
+		case ebmlDuration:
+			rawDuration = readFloat(r, body, size)
+		case ebmlPixelWidth:
+			if info.Width == 0 {
+				info.Width = int(readUint(r, body, size))
+			}
+		case ebmlPixelHeight:
+			if info.Height == 0 {
+				info.Height = int(readUint(r, body, size))
+			}
+		}
+		offset = body + size
+	}
+
+	if rawDuration > 0 {
+		info.Duration = rawDuration * timecodeScale / 1e9
+	}
+	return nil
+}
+
+// readVint 读取 EBML 变长整数，keepMarker 为 true 时保留长度标记位（用于元素 ID）
+// 长度字段全部为 1 时表示未知长度，返回 -1
+func readVint(r io.ReaderAt, offset int64, keepMarker bool) (int64, int, error) {
+	first := make([]byte, 1)
+	if _, err := r.ReadAt(first, offset); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, errNoVideoInfo
+	}
+
+	buf := make([]byte, length)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0, 0, err
+	}
+	if !keepMarker {
+		buf[0] &= 0xFF >> length
+	}
+	var value int64
+	allOnes := true
+	for i, b := range buf {
+		value = value<<8 | int64(b)
+		if (i == 0 && b != 0xFF>>length) || (i > 0 && b != 0xFF) {
+			allOnes = false
+		}
+	}
+	if !keepMarker && allOnes {
+		return -1, length, nil
+	}
+	return value, length, nil
+}
+
+// readUint 读取大端无符号整数元素
+func readUint(r io.ReaderAt, offset, size int64) uint64 {
+	if size <= 0 || size > 8 {
+		return 0
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0
+	}
+	var value uint64
+	for _, b := range buf {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// readFloat 读取 4 或 8 字节的浮点数元素
+func readFloat(r io.ReaderAt, offset, size int64) float64 {
+	if size != 4 && size != 8 {
+		return 0
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0
+	}
+	if size == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf)))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"slices"
+	"strconv"
 	"time"
 
 	"hosting/internal/global"
+	"hosting/internal/quota"
 	"hosting/internal/utils"
 )
 
@@ -78,33 +83,36 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// RequireAPIKey 验证 API Key 的中间件
+// Unwrap 返回原始的 ResponseWriter，供 http.ResponseController 使用
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// RequireAPIKey 验证 API Key 的中间件，并按 API Key 的配额限制上传
 func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 如果未启用 API Key 认证，直接放行
-		if !global.AppConfig.Security.RequireAPIKey {
-			next.ServeHTTP(w, r)
+		apiKey := requestAPIKey(r)
+		if !validateAPIKey(apiKey) {
+			// 如果未启用 API Key 认证，直接放行，匿名上传不受配额限制
+			if !global.AppConfig.Security.RequireAPIKey {
+				next.ServeHTTP(w, r)
+				return
+			}
+			writeUnauthorized(w, r, apiKey)
 			return
 		}
 
-		// 从请求头中获取 API Key
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" {
-			// 尝试从 Authorization Bearer 获取
-			authHeader := r.Header.Get("Authorization")
-			if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-				apiKey = authHeader[7:]
-			}
-		}
+		enforceQuota(w, r.WithContext(quota.WithKey(r.Context(), apiKey)), apiKey, next)
+	}
+}
 
-		// 验证 API Key
+// RequireValidAPIKey 无论是否启用 requireAPIKey 都要求有效的 API Key
+// 用于签名链接等会绕过访问控制的敏感接口
+func RequireValidAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := requestAPIKey(r)
 		if !validateAPIKey(apiKey) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			if _, werr := w.Write([]byte(`{"success":false,"message":"未授权：无效或缺失的API Key"}`)); werr != nil {
-				log.Printf("failed to write unauthorized API key response: %v", werr)
-			}
-			log.Printf("未授权的API访问尝试 - IP: %s, Key: %s", utils.ValidateIPAddress(r.RemoteAddr), maskAPIKey(apiKey))
+			writeUnauthorized(w, r, apiKey)
 			return
 		}
 
@@ -112,6 +120,115 @@ func RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requestAPIKey 从 X-API-Key 或 Authorization Bearer 请求头中获取 API Key
+func requestAPIKey(r *http.Request) string {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		authHeader := r.Header.Get("Authorization")
+		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+			apiKey = authHeader[7:]
+		}
+	}
+	return apiKey
+}
+
+// writeUnauthorized 返回 401 并记录未授权的访问
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, apiKey string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	if _, werr := w.Write([]byte(`{"success":false,"message":"未授权：无效或缺失的API Key"}`)); werr != nil {
+		log.Printf("failed to write unauthorized API key response: %v", werr)
+	}
+	log.Printf("未授权的API访问尝试 - IP: %s, Key: %s", utils.ValidateIPAddress(r.RemoteAddr), utils.MaskAPIKey(apiKey))
+}
+
+// enforceQuota 配额已用完时直接返回 429，不再读取请求体；否则在响应中附带 X-Quota-* 头
+// 文件的实际大小要在接收后才能确定，由上传流程在保存前再检查一次
+func enforceQuota(w http.ResponseWriter, r *http.Request, apiKey string, next http.HandlerFunc) {
+	limits := quota.For(apiKey)
+	if !limits.Enabled() {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	keyID := quota.KeyID(apiKey)
+	usage, err := quota.Load(keyID)
+	if err != nil {
+		// 无法读取用量时不阻止上传，避免数据库抖动导致接口整体不可用
+		log.Printf("failed to load quota usage for key %s: %v", utils.MaskAPIKey(apiKey), err)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// 只有写入数据的请求消耗配额，查询断点续传进度等请求不受影响
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		if err := limits.Check(usage, 0); err != nil {
+			writeQuotaExceeded(w, limits, usage, err)
+			log.Printf("API Key 配额已用完 - Key: %s, %v", utils.MaskAPIKey(apiKey), err)
+			return
+		}
+	}
+
+	next.ServeHTTP(&quotaWriter{ResponseWriter: w, keyID: keyID, limits: limits, usage: usage}, r)
+}
+
+// writeQuotaExceeded 返回 429，每日配额用完时通过 Retry-After 告知恢复时间
+func writeQuotaExceeded(w http.ResponseWriter, limits quota.Limits, usage quota.Usage, err error) {
+	quota.SetHeaders(w.Header(), limits, usage)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) && exceeded.Daily {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(quota.ResetTime()).Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	body, merr := json.Marshal(struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}{Message: err.Error()})
+	if merr != nil {
+		log.Printf("failed to encode quota response: %v", merr)
+		return
+	}
+	if _, werr := w.Write(body); werr != nil {
+		log.Printf("failed to write quota response: %v", werr)
+	}
+}
+
+// quotaWriter 在写入响应头时重新读取用量，使 X-Quota-* 头包含本次上传
+type quotaWriter struct {
+	http.ResponseWriter
+	keyID       string
+	limits      quota.Limits
+	usage       quota.Usage
+	wroteHeader bool
+}
+
+func (qw *quotaWriter) WriteHeader(code int) {
+	if !qw.wroteHeader {
+		qw.wroteHeader = true
+		if code < 300 {
+			if usage, err := quota.Load(qw.keyID); err == nil {
+				qw.usage = usage
+			}
+		}
+		quota.SetHeaders(qw.Header(), qw.limits, qw.usage)
+	}
+	qw.ResponseWriter.WriteHeader(code)
+}
+
+func (qw *quotaWriter) Write(b []byte) (int, error) {
+	if !qw.wroteHeader {
+		qw.WriteHeader(http.StatusOK)
+	}
+	return qw.ResponseWriter.Write(b)
+}
+
+// Unwrap 返回原始的 ResponseWriter，供 http.ResponseController 使用
+func (qw *quotaWriter) Unwrap() http.ResponseWriter {
+	return qw.ResponseWriter
+}
+
 // validateAPIKey 验证 API Key 是否有效
 func validateAPIKey(key string) bool {
 	if key == "" {
@@ -122,14 +239,6 @@ func validateAPIKey(key string) bool {
 	return slices.Contains(global.AppConfig.Security.APIKeys, key)
 }
 
-// maskAPIKey 遮蔽 API Key 用于日志记录
-func maskAPIKey(key string) string {
-	if len(key) <= 8 {
-		return "****"
-	}
-	return key[:4] + "****" + key[len(key)-4:]
-}
-
 // RequireAuthForUpload 根据配置决定是否需要登录才能上传
 // 如果配置了 requireLoginForUpload 为 true，则需要登录
 // 否则直接放行
@@ -0,0 +1,238 @@
+package quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"hosting/internal/db"
+	"hosting/internal/global"
+)
+
+// Limits 单个 API Key 的配额，0 表示不限制
+type Limits struct {
+	Uploads int   // 每天最多上传的文件数
+	Bytes   int64 // 每天最多上传的字节数
+	Storage int64 // 仍然有效的文件总字节数上限
+}
+
+// Usage API Key 的当前用量
+type Usage struct {
+	Uploads int
+	Bytes   int64
+	Storage int64
+}
+
+// ExceededError 本次上传会超过配额
+type ExceededError struct {
+	Message string
+	Daily   bool // 是否为每日配额，次日零点恢复
+}
+
+func (e *ExceededError) Error() string {
+	return e.Message
+}
+
+// contextKey 请求上下文中保存 API Key 的键
+type contextKey struct{}
+
+// WithKey 在请求上下文中记录本次请求使用的 API Key
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// KeyFromContext 返回请求使用的 API Key，匿名请求返回空字符串
+func KeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(contextKey{}).(string)
+	return key
+}
+
+// KeyID 数据库中代替 API Key 保存的标识，避免密钥明文落盘
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// For 返回 API Key 的配额，单独配置的优先于默认配额
+func For(key string) Limits {
+	cfg := global.AppConfig.Security.Quota
+	limits, ok := cfg.Keys[key]
+	if !ok {
+		limits = cfg.Default
+	}
+	return Limits{
+		Uploads: limits.UploadsPerDay,
+		Bytes:   int64(limits.DailyMB) * 1024 * 1024,
+		Storage: int64(limits.StorageMB) * 1024 * 1024,
+	}
+}
+
+// Enabled 是否设置了任何一项限制
+func (l Limits) Enabled() bool {
+	return l.Uploads > 0 || l.Bytes > 0 || l.Storage > 0
+}
+
+// Check 检查再上传 size 字节后是否超过配额，size 为 0 时只检查配额是否已经用完
+func (l Limits) Check(u Usage, size int64) error {
+	if l.Uploads > 0 && u.Uploads >= l.Uploads {
+		return &ExceededError{Message: fmt.Sprintf("今日上传次数已达上限 (%d)", l.Uploads), Daily: true}
+	}
+	if l.Bytes > 0 && (u.Bytes >= l.Bytes || u.Bytes+size > l.Bytes) {
+		return &ExceededError{Message: fmt.Sprintf("今日上传总大小将超过上限 (%dMB)", l.Bytes/1024/1024), Daily: true}
+	}
+	if l.Storage > 0 && (u.Storage >= l.Storage || u.Storage+size > l.Storage) {
+		return &ExceededError{Message: fmt.Sprintf("已保存的文件总大小将超过上限 (%dMB)", l.Storage/1024/1024)}
+	}
+	return nil
+}
+
+// today 用量按本地日期统计，与图片每日流量限制一致
+func today() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+// ResetTime 每日配额下次恢复的时间，即明天零点（本地时间）
+func ResetTime() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+}
+
+// Load 读取 API Key 今天的上传次数、上传字节数和仍然有效的文件总大小
+func Load(keyID string) (Usage, error) {
+	var u Usage
+	err := db.WithDBTimeout(func(ctx context.Context) error {
+		err := global.DB.QueryRowContext(ctx,
+			"SELECT uploads, bytes FROM api_key_usage WHERE key_id = ? AND day = ?", keyID, today(),
+		).Scan(&u.Uploads, &u.Bytes)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return global.DB.QueryRowContext(ctx,
+			"SELECT COALESCE(SUM(file_size), 0) FROM images WHERE api_key_id = ? AND is_active = 1", keyID,
+		).Scan(&u.Storage)
+	})
+	return u, err
+}
+
+// Record 记录一次成功的上传
+func Record(keyID string, size int64) error {
+	return db.WithDBTimeout(func(ctx context.Context) error {
+		_, err := global.DB.ExecContext(ctx, `
+			INSERT INTO api_key_usage (key_id, day, uploads, bytes) VALUES (?, ?, 1, ?)
+			ON CONFLICT (key_id, day) DO UPDATE SET uploads = uploads + 1, bytes = bytes + excluded.bytes`,
+			keyID, today(), size,
+		)
+		return err
+	})
+}
+
+// keyState 单个 API Key 正在进行、尚未写入数据库的上传占用的配额
+type keyState struct {
+	mu      sync.Mutex
+	pending Usage
+}
+
+// states 按 API Key 标识保存的预占状态，API Key 来自配置，数量有限，不需要清理
+var (
+	statesMu sync.Mutex
+	states   = map[string]*keyState{}
+)
+
+// stateFor 返回 API Key 的预占状态，首次使用时创建
+func stateFor(keyID string) *keyState {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	state, ok := states[keyID]
+	if !ok {
+		state = &keyState{}
+		states[keyID] = state
+	}
+	return state
+}
+
+// Reservation 一次上传预先占用的配额，上传结束后必须调用 Commit 或 Release
+type Reservation struct {
+	keyID string
+	size  int64
+	done  bool
+}
+
+// Reserve 检查并占用一次上传 size 字节的配额
+// 同一个 API Key 的检查在同一把锁内完成，并计入其他尚未完成的上传，并发请求不会越过限制
+func Reserve(keyID string, l Limits, size int64) (*Reservation, error) {
+	state := stateFor(keyID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	usage, err := Load(keyID)
+	if err != nil {
+		return nil, err
+	}
+	usage.Uploads += state.pending.Uploads
+	usage.Bytes += state.pending.Bytes
+	usage.Storage += state.pending.Storage
+	if err := l.Check(usage, size); err != nil {
+		return nil, err
+	}
+
+	state.pending.Uploads++
+	state.pending.Bytes += size
+	state.pending.Storage += size
+	return &Reservation{keyID: keyID, size: size}, nil
+}
+
+// Commit 上传成功，记录用量并释放预占，实际保存的大小可能与预占时不同
+func (res *Reservation) Commit(size int64) error {
+	state := stateFor(res.keyID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	err := Record(res.keyID, size)
+	res.release(state)
+	return err
+}
+
+// Release 上传失败，归还预占的配额，已经 Commit 时不做任何事
+func (res *Reservation) Release() {
+	state := stateFor(res.keyID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	res.release(state)
+}
+
+func (res *Reservation) release(state *keyState) {
+	if res.done {
+		return
+	}
+	res.done = true
+	state.pending.Uploads--
+	state.pending.Bytes -= res.size
+	state.pending.Storage -= res.size
+}
+
+// SetHeaders 写入 X-Quota-* 响应头，只包含设置了的限制
+func SetHeaders(h http.Header, l Limits, u Usage) {
+	// API 允许跨域访问，需要声明浏览器中脚本可以读取的响应头
+	h.Add("Access-Control-Expose-Headers", "X-Quota-Uploads-Limit, X-Quota-Uploads-Remaining, X-Quota-Bytes-Limit, X-Quota-Bytes-Remaining, X-Quota-Storage-Limit, X-Quota-Storage-Remaining, X-Quota-Reset, Retry-After")
+	if l.Uploads > 0 {
+		h.Set("X-Quota-Uploads-Limit", strconv.Itoa(l.Uploads))
+		h.Set("X-Quota-Uploads-Remaining", strconv.Itoa(max(l.Uploads-u.Uploads, 0)))
+	}
+	if l.Bytes > 0 {
+		h.Set("X-Quota-Bytes-Limit", strconv.FormatInt(l.Bytes, 10))
+		h.Set("X-Quota-Bytes-Remaining", strconv.FormatInt(max(l.Bytes-u.Bytes, 0), 10))
+	}
+	if l.Storage > 0 {
+		h.Set("X-Quota-Storage-Limit", strconv.FormatInt(l.Storage, 10))
+		h.Set("X-Quota-Storage-Remaining", strconv.FormatInt(max(l.Storage-u.Storage, 0), 10))
+	}
+	if l.Uploads > 0 || l.Bytes > 0 {
+		h.Set("X-Quota-Reset", strconv.FormatInt(ResetTime().Unix(), 10))
+	}
+}
@@ -0,0 +1,212 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrInvalidURL 链接格式无效或不是 http/https
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrForbiddenAddress 目标地址是内网、回环或链路本地地址
+	ErrForbiddenAddress = errors.New("forbidden address")
+	// ErrTooManyRedirects 重定向次数超过限制
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrTooLarge 文件超过大小限制
+	ErrTooLarge = errors.New("file too large")
+)
+
+// forbiddenPrefixes 除 netip.Addr 自带判断外需要额外拒绝的保留网段
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // 本网络
+	netip.MustParsePrefix("100.64.0.0/10"),   // 运营商级 NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF 协议分配
+	netip.MustParsePrefix("198.18.0.0/15"),   // 基准测试
+	netip.MustParsePrefix("240.0.0.0/4"),     // 保留及广播地址
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64，可映射到内网 IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // 本地 NAT64
+	netip.MustParsePrefix("2002::/16"),       // 6to4，可嵌入任意 IPv4
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("fec0::/10"),       // 已废弃的站点本地地址
+	netip.MustParsePrefix("100::/64"),        // 丢弃前缀
+	netip.MustParsePrefix("2001:db8::/32"),   // 文档示例
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4 转换地址
+}
+
+// Options 抓取远程文件的限制
+type Options struct {
+	MaxBytes     int64         // 最大文件大小
+	Timeout      time.Duration // 整个请求（含重定向和读取响应）的超时时间
+	MaxRedirects int           // 最多跟随的重定向次数
+	UserAgent    string
+}
+
+// File 抓取到的远程文件
+type File struct {
+	Body        io.ReadCloser // 响应体，读取超过大小限制时返回 ErrTooLarge，调用方负责关闭
+	Filename    string        // 从 Content-Disposition 或最终链接的路径中得到的文件名
+	ContentType string        // 服务器声明的类型，不可信，仅供参考
+	FinalURL    string        // 跟随重定向后的地址
+}
+
+// ForbiddenIP 判断地址是否禁止访问：回环、内网、链路本地、组播及其他保留地址
+func ForbiddenIP(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseURL 校验链接格式，只允许 http 和 https，且不能包含用户名密码
+func ParseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return nil, ErrInvalidURL
+	}
+	return u, nil
+}
+
+// newClient 创建只能连接公网地址的 HTTP 客户端
+// 地址检查放在拨号阶段，作用于 DNS 解析后的实际 IP，可以防止 DNS 重绑定绕过
+func newClient(opts Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || ForbiddenIP(addr) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// 不使用环境变量中的代理，否则地址检查只会作用于代理本身
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+}
+
+// Fetch 请求远程文件，连接到禁止的地址、状态码或类型不对时返回错误
+// 响应体不在这里读取，调用方边读边保存，超过大小限制或超时时读取返回错误
+// accept 用于在读取响应体之前根据声明的类型提前拒绝，为 nil 时不检查
+func Fetch(ctx context.Context, rawURL string, opts Options, accept func(contentType string) bool) (*File, error) {
+	u, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+
+	resp, err := newClient(opts).Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		err = fmt.Errorf("remote server returned %s", resp.Status)
+	case accept != nil && !accept(contentType):
+		err = fmt.Errorf("unexpected content type %q", contentType)
+	case resp.ContentLength > opts.MaxBytes:
+		err = ErrTooLarge
+	}
+	if err != nil {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("failed to close remote response body: %v", cerr)
+		}
+		return nil, err
+	}
+
+	return &File{
+		Body:        &limitedBody{ReadCloser: resp.Body, remaining: opts.MaxBytes},
+		Filename:    remoteFilename(resp),
+		ContentType: contentType,
+		FinalURL:    resp.Request.URL.String(),
+	}, nil
+}
+
+// limitedBody 最多读取 remaining 字节，之后还有数据时返回 ErrTooLarge
+// 服务器可能不返回或谎报 Content-Length，只能在读取时判断
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// 已经读满限制，多读一个字节判断后面是否还有数据
+		var extra [1]byte
+		n, err := b.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// remoteFilename 优先使用 Content-Disposition 中的文件名，其次是最终链接路径的最后一段
+func remoteFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "" && name != "." && name != "/" {
+		return name
+	}
+	return "remote"
+}
@@ -0,0 +1,215 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ErrNotSVG 文件不是有效的 SVG 文档
+var ErrNotSVG = errors.New("not a valid SVG document")
+
+// allowedElements 允许保留的 SVG 元素，不在列表中的元素连同其子元素一起移除
+// 不包含 script、foreignObject、a、iframe、set、animate 等可执行脚本或跳转的元素
+var allowedElements = map[string]bool{
+	"svg": true, "g": true, "defs": true, "symbol": true, "use": true, "switch": true, "view": true,
+	"title": true, "desc": true, "metadata": true, "style": true,
+	"path": true, "rect": true, "circle": true, "ellipse": true, "line": true, "polyline": true, "polygon": true,
+	"text": true, "tspan": true, "textPath": true, "image": true, "marker": true, "pattern": true,
+	"clipPath": true, "mask": true, "linearGradient": true, "radialGradient": true, "stop": true,
+	"filter": true, "feBlend": true, "feColorMatrix": true, "feComponentTransfer": true, "feComposite": true,
+	"feConvolveMatrix": true, "feDiffuseLighting": true, "feDisplacementMap": true, "feDistantLight": true,
+	"feDropShadow": true, "feFlood": true, "feFuncA": true, "feFuncB": true, "feFuncG": true, "feFuncR": true,
+	"feGaussianBlur": true, "feImage": true, "feMerge": true, "feMergeNode": true, "feMorphology": true,
+	"feOffset": true, "fePointLight": true, "feSpecularLighting": true, "feSpotLight": true, "feTile": true,
+	"feTurbulence": true,
+}
+
+// allowedNamespaces 允许声明的命名空间前缀
+var allowedNamespaces = map[string]string{
+	"":      "http://www.w3.org/2000/svg",
+	"svg":   "http://www.w3.org/2000/svg",
+	"xlink": "http://www.w3.org/1999/xlink",
+}
+
+// cssURLPattern 匹配 CSS 和属性值中的 url(...) 引用
+var cssURLPattern = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]*)`)
+
+// safeDataImage 允许内嵌的位图 data URL
+var safeDataImage = regexp.MustCompile(`(?i)^data:image/(png|jpeg|jpg|gif|webp);base64,`)
+
+// Sanitize 解析 SVG 并移除脚本、事件处理属性、外部引用和 foreignObject 等不安全内容，
+// 返回重新序列化后的文档。根元素不是 svg 时返回 ErrNotSVG
+func Sanitize(r io.Reader) ([]byte, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = true
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+
+	depth := 0     // 当前保留的元素层级
+	skipDepth := 0 // 正在跳过的元素层级，大于 0 时忽略所有内容
+	var stack []string
+	seenRoot := false
+	// style 中的文本可能被 CDATA 或注释分成多段，合并后在元素结束时整体检查
+	var styleText []byte
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			if depth == 0 {
+				if seenRoot || t.Name.Local != "svg" || (t.Name.Space != "" && t.Name.Space != "svg") {
+					return nil, ErrNotSVG
+				}
+				seenRoot = true
+			}
+			// style 中只允许文本，其中的元素一律丢弃
+			inStyle := depth > 0 && stack[depth-1] == "style"
+			if inStyle || (t.Name.Space != "" && t.Name.Space != "svg") || !allowedElements[t.Name.Local] {
+				skipDepth = 1
+				continue
+			}
+
+			name := qualifiedName(t.Name)
+			out.WriteString("<" + name)
+			for _, attr := range t.Attr {
+				if value, ok := sanitizeAttr(t.Name.Local, attr); ok {
+					out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+					if err := xml.EscapeText(&out, []byte(value)); err != nil {
+						return nil, err
+					}
+					out.WriteString(`"`)
+				}
+			}
+			out.WriteString(">")
+			stack = append(stack, name)
+			depth++
+
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if depth == 0 {
+				return nil, ErrNotSVG
+			}
+			depth--
+			if stack[depth] == "style" {
+				if !unsafeCSS(string(styleText)) {
+					if err := xml.EscapeText(&out, styleText); err != nil {
+						return nil, err
+					}
+				}
+				styleText = styleText[:0]
+			}
+			out.WriteString("</" + stack[depth] + ">")
+			stack = stack[:depth]
+
+		case xml.CharData:
+			if skipDepth > 0 || depth == 0 {
+				continue
+			}
+			if stack[depth-1] == "style" {
+				styleText = append(styleText, t...)
+				continue
+			}
+			if err := xml.EscapeText(&out, t); err != nil {
+				return nil, err
+			}
+
+			// 注释、处理指令和 DOCTYPE（可能定义实体）全部丢弃
+		case xml.Comment, xml.ProcInst, xml.Directive:
+		}
+	}
+
+	if !seenRoot || depth != 0 {
+		return nil, ErrNotSVG
+	}
+	return out.Bytes(), nil
+}
+
+// qualifiedName 返回带前缀的原始名称
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// sanitizeAttr 判断属性是否可以保留，返回清理后的属性值
+func sanitizeAttr(element string, attr xml.Attr) (string, bool) {
+	local := attr.Name.Local
+	value := attr.Value
+
+	switch attr.Name.Space {
+	case "xmlns":
+		// 只保留 SVG 和 xlink 命名空间声明
+		return value, allowedNamespaces[local] == value
+	case "":
+		if local == "xmlns" {
+			return allowedNamespaces[""], true
+		}
+	case "xlink", "xml":
+	default:
+		return "", false
+	}
+
+	// 事件处理属性
+	if strings.HasPrefix(strings.ToLower(local), "on") {
+		return "", false
+	}
+
+	if local == "href" {
+		return value, safeReference(element, value)
+	}
+
+	if unsafeCSS(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// safeReference 只允许文档内部的锚点引用，image 和 feImage 额外允许内嵌的位图 data URL
+func safeReference(element, value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "#") {
+		return true
+	}
+	return (element == "image" || element == "feImage") && safeDataImage.MatchString(value)
+}
+
+// unsafeCSSKeywords 出现即视为不安全的内容，image-set() 可以不经过 url() 直接引用外部图片
+var unsafeCSSKeywords = []string{"\\", "@import", "javascript:", "expression(", "image-set("}
+
+// unsafeCSS 检查样式或属性值中是否包含外部资源引用或脚本
+// CSS 转义序列可以绕过关键字检查，包含反斜杠的内容一律视为不安全
+func unsafeCSS(value string) bool {
+	lower := strings.ToLower(value)
+	for _, keyword := range unsafeCSSKeywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	for _, match := range cssURLPattern.FindAllStringSubmatch(value, -1) {
+		target := strings.TrimSpace(match[1])
+		if !strings.HasPrefix(target, "#") && !safeDataImage.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
@@ -15,3 +15,25 @@ func InitTelegram() {
 		log.Fatal(err)
 	}
 }
+
+// SendAsPhoto 判断是否以图片消息发送，Telegram 只对 JPEG/PNG/WebP 生成预览，
+// 其他格式（GIF、AVIF、HEIF、BMP、TIFF、ICO、SVG）以文件方式发送以保留原始内容
+func SendAsPhoto(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/jpg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// SendAsVideo 判断是否以视频消息发送，只有 MP4 能在 Telegram 客户端中流式播放，
+// WebM 和 MOV 以文件方式发送，避免 Telegram 转码或拒收
+func SendAsVideo(contentType string) bool {
+	return contentType == "video/mp4"
+}
+
+// DeleteMessage 删除频道中保存文件的消息
+func DeleteMessage(chatID int64, messageID int) error {
+	_, err := global.Bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
@@ -1,10 +1,14 @@
 package template
 
 import (
+	"fmt"
 	"html/template"
 	"log"
 	"path/filepath"
+	"strings"
 	"sync"
+
+	"hosting/internal/imaging"
 )
 
 // 模板缓存
@@ -23,14 +27,28 @@ func InitTemplates() {
 		"subtract": func(a, b int) int {
 			return a - b
 		},
+		"isVideo": imaging.IsVideo,
+		"isImage": func(contentType string) bool {
+			return strings.HasPrefix(contentType, "image/")
+		},
+		// duration 将视频时长格式化为 m:ss 或 h:mm:ss
+		"duration": func(seconds float64) string {
+			total := int(seconds + 0.5)
+			if total >= 3600 {
+				return fmt.Sprintf("%d:%02d:%02d", total/3600, total%3600/60, total%60)
+			}
+			return fmt.Sprintf("%d:%02d", total/60, total%60)
+		},
 	}
 
 	// 列出所有需要加载的模板
 	templateFiles := map[string]string{
-		"home":   "templates/home.tmpl",
-		"upload": "templates/upload.tmpl",
-		"login":  "templates/login.tmpl",
-		"admin":  "templates/admin.tmpl",
+		"home":    "templates/home.tmpl",
+		"upload":  "templates/upload.tmpl",
+		"login":   "templates/login.tmpl",
+		"admin":   "templates/admin.tmpl",
+		"similar": "templates/similar.tmpl",
+		"unlock":  "templates/unlock.tmpl",
 	}
 
 	// 加载每个模板
@@ -0,0 +1,297 @@
+package tus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version 实现的 tus 协议版本
+const Version = "1.0.0"
+
+var (
+	// ErrNotFound 上传不存在或已过期
+	ErrNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch 请求中的偏移量与已接收的长度不一致
+	ErrOffsetMismatch = errors.New("offset mismatch")
+	// ErrLocked 同一个上传正在被另一个请求写入
+	ErrLocked = errors.New("upload is locked")
+	// ErrTooLarge 写入的数据超过声明的总长度
+	ErrTooLarge = errors.New("upload exceeds declared length")
+	// ErrInvalidMetadata Upload-Metadata 格式无效
+	ErrInvalidMetadata = errors.New("invalid metadata")
+)
+
+// Info 上传的元信息，创建时写入磁盘，已接收的长度由数据文件大小得到
+type Info struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"-"`
+	Metadata  map[string]string `json:"metadata"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	Result    json.RawMessage   `json:"result,omitempty"` // 文件保存后的结果，设置后数据文件已删除
+}
+
+// Store 将分块上传的数据暂存在本地目录中
+// 每个上传对应一个数据文件和一个 .info 文件，服务重启后仍可继续上传
+type Store struct {
+	dir        string
+	expiration time.Duration
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// NewStore 创建暂存目录，expiration 为上传创建后的有效期
+func NewStore(dir string, expiration time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, expiration: expiration, locked: make(map[string]bool)}, nil
+}
+
+// dataPath 上传数据文件的路径
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// infoPath 上传元信息文件的路径
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// Create 创建一个新的上传
+func (s *Store) Create(length int64, metadata map[string]string) (*Info, error) {
+	info := &Info{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(s.expiration).UTC().Truncate(time.Second),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.dataPath(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.infoPath(info.ID), data, 0600); err != nil {
+		return nil, errors.Join(err, s.remove(info.ID))
+	}
+	return info, nil
+}
+
+// Get 读取上传的元信息和已接收的长度，过期的上传会被删除并返回 ErrNotFound
+func (s *Store) Get(id string) (*Info, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	if time.Now().After(info.ExpiresAt) {
+		if err := s.remove(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	if len(info.Result) > 0 {
+		info.Offset = info.Length
+		return &info, nil
+	}
+	stat, err := os.Stat(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Offset = stat.Size()
+	return &info, nil
+}
+
+// Lock 独占一个上传，防止并发的 PATCH 请求交错写入
+func (s *Store) Lock(id string) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return nil, ErrLocked
+	}
+	s.locked[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.locked, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+// Write 从 offset 处追加数据，返回写入后的偏移量，调用方需要先持有锁
+// 连接中断时已写入的部分仍然保留，客户端可以从新的偏移量继续上传
+func (s *Store) Write(id string, offset int64, r io.Reader) (int64, error) {
+	info, err := s.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != info.Offset {
+		return info.Offset, ErrOffsetMismatch
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return offset, err
+	}
+	remaining := info.Length - offset
+	n, copyErr := io.Copy(file, io.LimitReader(r, remaining))
+	if closeErr := file.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		return offset + n, copyErr
+	}
+
+	// 超出声明长度的数据不接受，回退本次写入
+	if n == remaining {
+		var probe [1]byte
+		if extra, _ := r.Read(probe[:]); extra > 0 {
+			if err := os.Truncate(s.dataPath(id), offset); err != nil {
+				return offset + n, err
+			}
+			return offset, ErrTooLarge
+		}
+	}
+	return offset + n, nil
+}
+
+// Finish 记录保存结果并删除数据文件，结果保留到上传过期，供丢失最终响应的客户端查询
+func (s *Store) Finish(id string, result json.RawMessage) error {
+	info, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	info.Result = result
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.infoPath(id), data, 0600); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Open 打开上传的数据文件用于读取
+func (s *Store) Open(id string) (*os.File, error) {
+	if uuid.Validate(id) != nil {
+		return nil, ErrNotFound
+	}
+	return os.Open(s.dataPath(id))
+}
+
+// Remove 删除上传的数据和元信息
+func (s *Store) Remove(id string) error {
+	if uuid.Validate(id) != nil {
+		return ErrNotFound
+	}
+	return s.remove(id)
+}
+
+// remove 删除数据文件和元信息文件，文件不存在时忽略
+func (s *Store) remove(id string) error {
+	var errs []error
+	for _, path := range []string{s.dataPath(id), s.infoPath(id)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Cleanup 删除已过期的上传以及没有元信息文件的残留数据，返回删除的数量
+func (s *Store) Cleanup() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok {
+			if s.removeOrphan(entry) {
+				count++
+			}
+			continue
+		}
+		if uuid.Validate(id) != nil {
+			continue
+		}
+		if unlock, err := s.Lock(id); err == nil {
+			// Get 发现过期时会删除文件
+			if _, err := s.Get(id); errors.Is(err, ErrNotFound) {
+				count++
+			}
+			unlock()
+		}
+	}
+	return count, nil
+}
+
+// removeOrphan 删除写入元信息前中断而残留的数据文件
+// 创建上传时先建数据文件再写元信息，只清理超过有效期的文件，避免误删正在创建的上传
+func (s *Store) removeOrphan(entry os.DirEntry) bool {
+	id := entry.Name()
+	if uuid.Validate(id) != nil || !entry.Type().IsRegular() {
+		return false
+	}
+	if _, err := os.Stat(s.infoPath(id)); !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	stat, err := entry.Info()
+	if err != nil || time.Since(stat.ModTime()) < s.expiration {
+		return false
+	}
+	return os.Remove(s.dataPath(id)) == nil
+}
+
+// ParseMetadata 解析 Upload-Metadata 请求头，格式为逗号分隔的 "key base64(value)"，值可以省略
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, ErrInvalidMetadata
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMetadata, key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
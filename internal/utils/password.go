@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// passwordIterations PBKDF2 迭代次数
+const passwordIterations = 210000
+
+// HashPassword 使用 PBKDF2-SHA256 和随机盐生成密码哈希，格式为 pbkdf2-sha256$迭代次数$盐$哈希
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword 校验密码是否与 HashPassword 生成的哈希匹配
+func CheckPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(key, expected)
+}
+
+// UnlockToken 为输入密码后的访问生成有时效的令牌，格式为 过期时间.签名
+// subject 应包含密码哈希，修改密码后旧令牌随之失效
+func UnlockToken(subject string, ttl time.Duration) string {
+	exp := time.Now().Add(ttl).Unix()
+	return strconv.FormatInt(exp, 10) + "." + computeSignature("unlock\n"+subject, exp)
+}
+
+// VerifyUnlockToken 校验 UnlockToken 生成的令牌
+func VerifyUnlockToken(subject, token string) bool {
+	expParam, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(computeSignature("unlock\n"+subject, exp)))
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"hosting/internal/global"
+)
+
+// DefaultSignedURLTTL 签名链接的默认有效期
+const DefaultSignedURLTTL = 24 * time.Hour
+
+// signingSecret 返回用于签名链接的密钥，未配置时启动时会使用保存在数据库中的密钥
+func signingSecret() []byte {
+	return []byte(global.AppConfig.Security.URLSigningSecret)
+}
+
+// SignedURLTTL 返回配置的签名链接默认有效期
+func SignedURLTTL() time.Duration {
+	if ttl := global.AppConfig.Security.SignedURLTTL; ttl != "" {
+		if d, err := ParseFlexibleDuration(ttl); err == nil && d > 0 {
+			return d
+		}
+	}
+	return DefaultSignedURLTTL
+}
+
+// computeSignature 计算 path 和过期时间的 HMAC-SHA256 签名
+func computeSignature(path string, exp int64) string {
+	mac := hmac.New(sha256.New, signingSecret())
+	fmt.Fprintf(mac, "%s\n%d", path, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignPath 为访问路径生成带过期时间的签名链接，格式为 path?exp=...&sig=...
+func SignPath(path string, ttl time.Duration) string {
+	exp := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("sig", computeSignature(path, exp))
+	return path + "?" + query.Encode()
+}
+
+// VerifySignedPath 校验签名链接，返回签名是否有效以及过期时间
+func VerifySignedPath(path, expParam, sig string) (time.Time, bool) {
+	if expParam == "" || sig == "" {
+		return time.Time{}, false
+	}
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expiresAt := time.Unix(exp, 0)
+	if time.Now().After(expiresAt) {
+		return expiresAt, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(computeSignature(path, exp))) {
+		return expiresAt, false
+	}
+	return expiresAt, true
+}
@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"crypto/rand"
 	"fmt"
+	"math"
+	"math/big"
 	"net"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,8 +22,11 @@ func GetFileExtension(mimeType string) (string, bool) {
 
 func NormalizeFileExtension(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
-	if ext == ".jpeg" {
+	switch ext {
+	case ".jpeg":
 		return ".jpg"
+	case ".tif":
+		return ".tiff"
 	}
 	return ext
 }
@@ -61,3 +68,112 @@ func SanitizeFilename(filename string) string {
 func GetPageTitle(page string) string {
 	return fmt.Sprintf("%s | %s", page, global.AppConfig.Site.Name)
 }
+
+// base62Chars 短链接使用的字符集
+const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// RandomBase62 使用加密安全的随机数生成指定长度的 base62 字符串
+func RandomBase62(length int) (string, error) {
+	max := big.NewInt(int64(len(base62Chars)))
+	buf := make([]byte, length)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = base62Chars[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// MatchHost 判断主机名是否匹配列表中的任一规则
+// 支持精确匹配、"*.example.com" 形式的子域名通配（不含 example.com 本身）以及匹配全部的 "*"
+func MatchHost(host string, patterns []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "":
+			continue
+		case pattern == "*":
+			return true
+		case strings.HasPrefix(pattern, "*."):
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		case host == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFlexibleDuration 解析时长，支持纯秒数、以 d 结尾的天数（如 "7d"）和 Go duration（如 "1h30m"）
+func ParseFlexibleDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return scaleDuration(value, seconds, time.Second)
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		return scaleDuration(value, n, 24*time.Hour)
+	}
+	return time.ParseDuration(value)
+}
+
+// scaleDuration 计算 n 个 unit 的时长，超出 time.Duration 的范围时返回错误而不是溢出
+func scaleDuration(value string, n int64, unit time.Duration) (time.Duration, error) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, fmt.Errorf("duration out of range: %s", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// ContentDisposition 生成 Content-Disposition 响应头，disposition 为 inline 或 attachment
+// filename 参数只保留 ASCII 字符供旧浏览器使用，filename* 按 RFC 5987 编码完整的 UTF-8 文件名
+func ContentDisposition(disposition, filename string) string {
+	var fallback, encoded strings.Builder
+	for _, r := range filename {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			continue
+		case r > 0x7e || r == '"' || r == '\\' || r == '%':
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else if b >= 0x20 && b != 0x7f {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	if fallback.String() == encoded.String() {
+		return fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback.String(), encoded.String())
+}
+
+// isAttrChar 判断字节是否属于 RFC 5987 中无需编码的 attr-char
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// MaskAPIKey 遮蔽 API Key，用于日志记录和页面显示
+func MaskAPIKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}